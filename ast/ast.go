@@ -0,0 +1,683 @@
+// Package ast declares the types used to represent the syntax tree of queries.
+package ast
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsubaki/gostream/lexer"
+)
+
+var (
+	_ Expr = (*Star)(nil)
+	_ Expr = (*Ident)(nil)
+	_ Expr = (*BasicLit)(nil)
+	_ Expr = (*Param)(nil)
+	_ Expr = (*Call)(nil)
+	_ Expr = (*BinaryExpr)(nil)
+	_ Expr = (*Case)(nil)
+	_ Expr = (*Alias)(nil)
+)
+
+// Node is a node of the syntax tree.
+// String returns the node formatted as a query, which parses back to the same node.
+type Node interface {
+	String() string
+}
+
+// Expr is an expression in the select list, the where clause or the order by clause.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Star is the * of SELECT * and COUNT(*).
+type Star struct{}
+
+// Ident is a field name.
+// A dotted path into nested structs such as Req.Header.UserAgent is a single Ident.
+type Ident struct {
+	Name string
+}
+
+// BasicLit is a literal of kind INT, FLOAT, DURATION, TRUE, FALSE, NULL or IDENT as it is written in the query,
+// or of kind STRING or TIMESTAMP with Value the string without quotes and escape sequences.
+type BasicLit struct {
+	Kind  lexer.Token
+	Value string
+}
+
+// Param is a placeholder for a value bound when the query is run,
+// either ? with Index counting the ? placeholders from 0, or :Name.
+type Param struct {
+	Name  string
+	Index int
+}
+
+// Call is a function call such as AVG(Level) or PERCENTILE(Latency, 99),
+// or a call of the scalar function Name such as UPPER(Message) if Name is not empty.
+// Names of scalar functions are case-insensitive and kept in lower case.
+type Call struct {
+	Func lexer.Token
+	Name string
+	Args []Expr
+}
+
+// BinaryExpr is a comparison such as Level > 2 or UPPER(Message) = 'GET',
+// or the conjunction of two expressions with AND.
+type BinaryExpr struct {
+	Op lexer.Token
+	X  Expr
+	Y  Expr
+}
+
+// Case is a conditional expression such as CASE WHEN Latency > 500 THEN 'slow' ELSE 'ok' END,
+// or CASE Level WHEN 1 THEN 'info' END that compares Value with the conditions of When.
+// Value and Else are nil if they are not given.
+type Case struct {
+	Value Expr
+	When  []When
+	Else  Expr
+}
+
+// When is a WHEN Cond THEN Then clause of a Case.
+type When struct {
+	Cond Expr
+	Then Expr
+}
+
+// Alias is an expression in the select list named with AS such as CASE ... END AS bucket.
+type Alias struct {
+	Expr Expr
+	Name string
+}
+
+func (*Star) exprNode()       {}
+func (*Ident) exprNode()      {}
+func (*BasicLit) exprNode()   {}
+func (*Param) exprNode()      {}
+func (*Call) exprNode()       {}
+func (*BinaryExpr) exprNode() {}
+func (*Case) exprNode()       {}
+func (*Alias) exprNode()      {}
+
+func (x *Star) String() string {
+	return "*"
+}
+
+func (x *Ident) String() string {
+	return x.Name
+}
+
+func (x *BasicLit) String() string {
+	switch x.Kind {
+	case lexer.STRING:
+		return quote(x.Value)
+	case lexer.TIMESTAMP:
+		return fmt.Sprintf("%v %v", lexer.Tokens[lexer.TIMESTAMP], quote(x.Value))
+	}
+
+	return x.Value
+}
+
+var escape = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+)
+
+// quote returns s as a string literal in single quotes.
+func quote(s string) string {
+	return fmt.Sprintf("'%v'", escape.Replace(s))
+}
+
+func (x *Param) String() string {
+	if x.Name == "" {
+		return "?"
+	}
+
+	return fmt.Sprintf(":%v", x.Name)
+}
+
+func (x *Call) String() string {
+	args := make([]string, len(x.Args))
+	for i := range x.Args {
+		args[i] = x.Args[i].String()
+	}
+
+	if x.Name != "" {
+		return fmt.Sprintf("%v(%v)", strings.ToUpper(x.Name), strings.Join(args, ", "))
+	}
+
+	return fmt.Sprintf("%v(%v)", lexer.Tokens[x.Func], strings.Join(args, ", "))
+}
+
+func (x *BinaryExpr) String() string {
+	return fmt.Sprintf("%v %v %v", x.X, lexer.Tokens[x.Op], x.Y)
+}
+
+func (x *Case) String() string {
+	var buf strings.Builder
+	buf.WriteString("CASE")
+	if x.Value != nil {
+		buf.WriteString(fmt.Sprintf(" %v", x.Value))
+	}
+
+	for _, w := range x.When {
+		buf.WriteString(fmt.Sprintf(" WHEN %v THEN %v", w.Cond, w.Then))
+	}
+
+	if x.Else != nil {
+		buf.WriteString(fmt.Sprintf(" ELSE %v", x.Else))
+	}
+
+	buf.WriteString(" END")
+	return buf.String()
+}
+
+func (x *Alias) String() string {
+	return fmt.Sprintf("%v AS %v", x.Expr, x.Name)
+}
+
+// Window is the window of a query such as LENGTH(10) or TIME(1 MIN 30 SEC).
+// Length is used by LENGTH and LENGTH_BATCH, Intervals by TIME and TIME_BATCH.
+type Window struct {
+	Kind      lexer.Token
+	Length    int
+	Intervals []Interval
+}
+
+func (w *Window) String() string {
+	switch w.Kind {
+	case lexer.TIME, lexer.TIME_BATCH:
+		return fmt.Sprintf("%v(%v)", lexer.Tokens[w.Kind], Intervals(w.Intervals))
+	}
+
+	return fmt.Sprintf("%v(%v)", lexer.Tokens[w.Kind], w.Length)
+}
+
+// Duration returns the length of the time window, the sum of its intervals.
+func (w *Window) Duration() time.Duration {
+	var d time.Duration
+	for _, i := range w.Intervals {
+		d += i.Duration()
+	}
+
+	return d
+}
+
+// Pattern is a sequence of events such as
+// PATTERN [EVERY a=LoginFail -> b=LoginOK(b.User = a.User) WHERE TIMER:WITHIN(1 MIN)],
+// in which the event of each step is followed by the event of the next step.
+// Every starts a match at each event of the first step, not only at the first one.
+// Within is the time from the first to the last event of a match, or nil for no limit.
+type Pattern struct {
+	Every  bool
+	Steps  []Step
+	Within []Interval
+}
+
+// Step is an event of a Pattern such as b=LoginOK(b.User = a.User),
+// of the type From, tagged Tag and satisfying Where.
+// Tag is empty if it is not given, and Where is nil if the event has no condition.
+type Step struct {
+	Tag   string
+	From  string
+	Where Expr
+}
+
+func (x *Pattern) String() string {
+	steps := make([]string, len(x.Steps))
+	for i := range x.Steps {
+		steps[i] = x.Steps[i].String()
+	}
+
+	var buf strings.Builder
+	buf.WriteString("PATTERN [")
+	if x.Every {
+		buf.WriteString("EVERY ")
+	}
+
+	buf.WriteString(strings.Join(steps, " -> "))
+	if len(x.Within) > 0 {
+		buf.WriteString(fmt.Sprintf(" WHERE %v(%v)", lexer.Tokens[lexer.TIMER_WITHIN], Intervals(x.Within)))
+	}
+
+	buf.WriteString("]")
+	return buf.String()
+}
+
+// Duration returns the time limit of a match, the sum of the intervals of Within.
+func (x *Pattern) Duration() time.Duration {
+	var d time.Duration
+	for _, i := range x.Within {
+		d += i.Duration()
+	}
+
+	return d
+}
+
+func (x Step) String() string {
+	var buf strings.Builder
+	if x.Tag != "" {
+		buf.WriteString(fmt.Sprintf("%v=", x.Tag))
+	}
+
+	buf.WriteString(x.From)
+	if x.Where != nil {
+		buf.WriteString(fmt.Sprintf("(%v)", x.Where))
+	}
+
+	return buf.String()
+}
+
+// MatchRecognize is a MATCH_RECOGNIZE clause over the events in the window such as
+// MATCH_RECOGNIZE (PARTITION BY Symbol MEASURES A.Price AS low, LAST(C.Price) AS high PATTERN (A B+ C) DEFINE B AS B.Price > PREV(B.Price), C AS C.Price > PREV(C.Price)).
+// The fields in Measures and Define are qualified by the variables of Pattern,
+// and PREV, FIRST and LAST are calls of Func without Name.
+// OrderBy is nil if the events are in the order they arrived.
+type MatchRecognize struct {
+	PartitionBy []Expr
+	OrderBy     *OrderBy
+	Measures    []*Alias
+	Pattern     []Var
+	Define      []Define
+}
+
+// Var is a variable of the pattern of a MATCH_RECOGNIZE clause such as B+ or B{2,},
+// which matches from Min to Max consecutive events, or any number of events from Min if Max is -1, as many as possible.
+type Var struct {
+	Name string
+	Min  int
+	Max  int
+}
+
+// Define is the condition of a variable of the pattern of a MATCH_RECOGNIZE clause such as B AS B.Price > PREV(B.Price).
+type Define struct {
+	Name string
+	Cond Expr
+}
+
+func (x *MatchRecognize) String() string {
+	clauses := make([]string, 0)
+	if len(x.PartitionBy) > 0 {
+		clauses = append(clauses, fmt.Sprintf("%v %v", lexer.Tokens[lexer.PARTITION_BY], join(x.PartitionBy)))
+	}
+
+	if x.OrderBy != nil {
+		clauses = append(clauses, x.OrderBy.String())
+	}
+
+	if len(x.Measures) > 0 {
+		measures := make([]Expr, len(x.Measures))
+		for i := range x.Measures {
+			measures[i] = x.Measures[i]
+		}
+
+		clauses = append(clauses, fmt.Sprintf("%v %v", lexer.Tokens[lexer.MEASURES], join(measures)))
+	}
+
+	vars := make([]string, len(x.Pattern))
+	for i, v := range x.Pattern {
+		vars[i] = v.String()
+	}
+	clauses = append(clauses, fmt.Sprintf("%v (%v)", lexer.Tokens[lexer.PATTERN], strings.Join(vars, " ")))
+
+	if len(x.Define) > 0 {
+		define := make([]string, len(x.Define))
+		for i, d := range x.Define {
+			define[i] = fmt.Sprintf("%v AS %v", d.Name, d.Cond)
+		}
+
+		clauses = append(clauses, fmt.Sprintf("%v %v", lexer.Tokens[lexer.DEFINE], strings.Join(define, ", ")))
+	}
+
+	return fmt.Sprintf("%v (%v)", lexer.Tokens[lexer.MATCH_RECOGNIZE], strings.Join(clauses, " "))
+}
+
+func (v Var) String() string {
+	switch {
+	case v.Min == 1 && v.Max == 1:
+		return v.Name
+	case v.Min == 0 && v.Max == 1:
+		return v.Name + "?"
+	case v.Min == 0 && v.Max < 0:
+		return v.Name + "*"
+	case v.Min == 1 && v.Max < 0:
+		return v.Name + "+"
+	case v.Max < 0:
+		return fmt.Sprintf("%v{%v,}", v.Name, v.Min)
+	case v.Min == v.Max:
+		return fmt.Sprintf("%v{%v}", v.Name, v.Min)
+	}
+
+	return fmt.Sprintf("%v{%v,%v}", v.Name, v.Min, v.Max)
+}
+
+// join returns the expressions separated by commas.
+func join(x []Expr) string {
+	s := make([]string, len(x))
+	for i := range x {
+		s[i] = x[i].String()
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// Interval is a length of time such as 30 SEC or 0.5 SEC.
+// Value is an INT or FLOAT literal as it is written in the query.
+type Interval struct {
+	Value string
+	Unit  lexer.Token
+}
+
+func (i Interval) String() string {
+	return fmt.Sprintf("%v %v", i.Value, lexer.Tokens[i.Unit])
+}
+
+// Duration returns the length of the interval rounded to nanoseconds.
+func (i Interval) Duration() time.Duration {
+	v, _ := strconv.ParseFloat(i.Value, 64)
+	return time.Duration(math.Round(v * float64(Unit(i.Unit))))
+}
+
+// Intervals is the intervals of a time window, written one after another.
+type Intervals []Interval
+
+func (x Intervals) String() string {
+	s := make([]string, len(x))
+	for i := range x {
+		s[i] = x[i].String()
+	}
+
+	return strings.Join(s, " ")
+}
+
+// units are the units of time from the largest.
+var units = []lexer.Token{lexer.WEEK, lexer.DAY, lexer.HOUR, lexer.MIN, lexer.SEC, lexer.MSEC}
+
+// Unit returns the length of the time unit MSEC, SEC, MIN, HOUR, DAY or WEEK,
+// or 0 for other tokens.
+func Unit(unit lexer.Token) time.Duration {
+	switch unit {
+	case lexer.MSEC:
+		return time.Millisecond
+	case lexer.SEC:
+		return time.Second
+	case lexer.MIN:
+		return time.Minute
+	case lexer.HOUR:
+		return time.Hour
+	case lexer.DAY:
+		return 24 * time.Hour
+	case lexer.WEEK:
+		return 7 * 24 * time.Hour
+	}
+
+	return 0
+}
+
+// Split returns d as an interval in unit, e.g. 90 SEC or 1.5 MIN.
+// If unit is not a unit of time, d is split into the largest units instead,
+// e.g. 1 MIN 30 SEC, with any fraction of a millisecond in MSEC.
+func Split(d time.Duration, unit lexer.Token) Intervals {
+	if u := Unit(unit); u > 0 {
+		return Intervals{{Value: format(float64(d) / float64(u)), Unit: unit}}
+	}
+
+	out := make(Intervals, 0)
+	for _, u := range units[:len(units)-1] {
+		if n := d / Unit(u); n > 0 {
+			out = append(out, Interval{Value: strconv.FormatInt(int64(n), 10), Unit: u})
+			d -= n * Unit(u)
+		}
+	}
+
+	if d > 0 {
+		out = append(out, Interval{Value: format(float64(d) / float64(time.Millisecond)), Unit: lexer.MSEC})
+	}
+
+	if len(out) == 0 {
+		out = append(out, Interval{Value: "0", Unit: lexer.SEC})
+	}
+
+	return out
+}
+
+// format returns v in decimal notation such as 0.5 or 1500.
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// OrderBy is the order by clause of a query.
+type OrderBy struct {
+	Expr Expr
+	Desc bool
+}
+
+func (o *OrderBy) String() string {
+	if o.Desc {
+		return fmt.Sprintf("ORDER BY %v DESC", o.Expr)
+	}
+
+	return fmt.Sprintf("ORDER BY %v", o.Expr)
+}
+
+// Limit is the limit clause of a query.
+type Limit struct {
+	Limit  int
+	Offset int
+}
+
+func (l *Limit) String() string {
+	if l.Offset > 0 {
+		return fmt.Sprintf("LIMIT %v OFFSET %v", l.Limit, l.Offset)
+	}
+
+	return fmt.Sprintf("LIMIT %v", l.Limit)
+}
+
+// Output is the output clause of a query such as OUTPUT LAST EVERY 10 SEC or OUTPUT EVERY 100 EVENTS.
+// Kind is FIRST, LAST, SNAPSHOT, or ILLEGAL for all the events of a period.
+// The period is Events events, or the length of time of Intervals, or every event if neither is given.
+type Output struct {
+	Kind      lexer.Token
+	Events    int
+	Intervals []Interval
+}
+
+func (o *Output) String() string {
+	var buf strings.Builder
+	buf.WriteString(lexer.Tokens[lexer.OUTPUT])
+	if o.Kind != lexer.ILLEGAL {
+		buf.WriteString(" ")
+		buf.WriteString(lexer.Tokens[o.Kind])
+	}
+
+	switch {
+	case len(o.Intervals) > 0:
+		buf.WriteString(fmt.Sprintf(" %v %v", lexer.Tokens[lexer.EVERY], Intervals(o.Intervals)))
+	case o.Events > 0:
+		buf.WriteString(fmt.Sprintf(" %v %v EVENTS", lexer.Tokens[lexer.EVERY], o.Events))
+	}
+
+	return buf.String()
+}
+
+// Duration returns the length of time of the period, the sum of its intervals.
+func (o *Output) Duration() time.Duration {
+	var d time.Duration
+	for _, i := range o.Intervals {
+		d += i.Duration()
+	}
+
+	return d
+}
+
+// Query is a query such as SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 2.
+// The clauses that are not given in the query are nil.
+// From is empty if the events are the matches of Pattern.
+// Stream is ISTREAM, RSTREAM or IRSTREAM for the events inserted into and expired from the window,
+// or ILLEGAL for the events in the window.
+type Query struct {
+	Stream         lexer.Token
+	Fields         []Expr
+	From           string
+	Pattern        *Pattern
+	Window         *Window
+	MatchRecognize *MatchRecognize
+	Where          Expr
+	Output         *Output
+	OrderBy        *OrderBy
+	Limit          *Limit
+}
+
+func (q *Query) String() string {
+	fields := make([]string, len(q.Fields))
+	for i := range q.Fields {
+		fields[i] = q.Fields[i].String()
+	}
+
+	var buf strings.Builder
+	buf.WriteString("SELECT ")
+	if q.Stream != lexer.ILLEGAL {
+		buf.WriteString(lexer.Tokens[q.Stream])
+		buf.WriteString(" ")
+	}
+
+	buf.WriteString(strings.Join(fields, ", "))
+	buf.WriteString(" FROM ")
+	buf.WriteString(q.From)
+	if q.Pattern != nil {
+		buf.WriteString(q.Pattern.String())
+	}
+
+	if q.Window != nil {
+		buf.WriteString(".")
+		buf.WriteString(q.Window.String())
+	}
+
+	if q.MatchRecognize != nil {
+		buf.WriteString(" ")
+		buf.WriteString(q.MatchRecognize.String())
+	}
+
+	if q.Where != nil {
+		buf.WriteString(" WHERE ")
+		buf.WriteString(q.Where.String())
+	}
+
+	if q.Output != nil {
+		buf.WriteString(" ")
+		buf.WriteString(q.Output.String())
+	}
+
+	if q.OrderBy != nil {
+		buf.WriteString(" ")
+		buf.WriteString(q.OrderBy.String())
+	}
+
+	if q.Limit != nil {
+		buf.WriteString(" ")
+		buf.WriteString(q.Limit.String())
+	}
+
+	return buf.String()
+}
+
+// Conjuncts returns the expressions joined by AND in x, from left to right.
+func Conjuncts(x Expr) []Expr {
+	b, ok := x.(*BinaryExpr)
+	if !ok || b.Op != lexer.AND {
+		return []Expr{x}
+	}
+
+	return append(Conjuncts(b.X), Conjuncts(b.Y)...)
+}
+
+// And returns the conjunction of x and y. x may be nil.
+func And(x, y Expr) Expr {
+	if x == nil {
+		return y
+	}
+
+	return &BinaryExpr{Op: lexer.AND, X: x, Y: y}
+}
+
+// Field returns the expression for the field name used by the stream builder,
+// which is * or the name of a field.
+func Field(name string) Expr {
+	if name == "*" {
+		return &Star{}
+	}
+
+	return &Ident{Name: name}
+}
+
+// Inspect traverses x in depth-first order, calling f for each expression.
+// The children of an expression are not traversed if f returns false.
+func Inspect(x Expr, f func(Expr) bool) {
+	if x == nil || !f(x) {
+		return
+	}
+
+	switch x := x.(type) {
+	case *Call:
+		for _, a := range x.Args {
+			Inspect(a, f)
+		}
+	case *BinaryExpr:
+		Inspect(x.X, f)
+		Inspect(x.Y, f)
+	case *Case:
+		Inspect(x.Value, f)
+		for _, w := range x.When {
+			Inspect(w.Cond, f)
+			Inspect(w.Then, f)
+		}
+
+		Inspect(x.Else, f)
+	case *Alias:
+		Inspect(x.Expr, f)
+	}
+}
+
+// Params returns the placeholders in q in the order they appear.
+func Params(q *Query) []*Param {
+	out := make([]*Param, 0)
+	find := func(x Expr) bool {
+		if p, ok := x.(*Param); ok {
+			out = append(out, p)
+		}
+
+		return true
+	}
+
+	for _, f := range q.Fields {
+		Inspect(f, find)
+	}
+
+	if q.Pattern != nil {
+		for _, s := range q.Pattern.Steps {
+			Inspect(s.Where, find)
+		}
+	}
+
+	if q.MatchRecognize != nil {
+		for _, m := range q.MatchRecognize.Measures {
+			Inspect(m, find)
+		}
+
+		for _, d := range q.MatchRecognize.Define {
+			Inspect(d.Cond, find)
+		}
+	}
+
+	Inspect(q.Where, find)
+	return out
+}
@@ -0,0 +1,36 @@
+package ast_test
+
+import (
+	"fmt"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+)
+
+func ExampleQuery() {
+	q := &ast.Query{
+		Fields: []ast.Expr{
+			&ast.Ident{Name: "Message"},
+			&ast.Call{Func: lexer.PERCENTILE, Args: []ast.Expr{&ast.Ident{Name: "Latency"}, &ast.BasicLit{Kind: lexer.INT, Value: "99"}}},
+		},
+		From:   "LogEvent",
+		Window: &ast.Window{Kind: lexer.TIME, Intervals: []ast.Interval{{Value: "10", Unit: lexer.MIN}}},
+		Where: ast.And(
+			&ast.BinaryExpr{Op: lexer.LARGER, X: &ast.Ident{Name: "Level"}, Y: &ast.BasicLit{Kind: lexer.INT, Value: "2"}},
+			&ast.BinaryExpr{Op: lexer.EQUALS, X: &ast.Ident{Name: "Req.Method"}, Y: &ast.BasicLit{Kind: lexer.STRING, Value: "GET"}},
+		),
+		Output:  &ast.Output{Kind: lexer.LAST, Intervals: []ast.Interval{{Value: "1", Unit: lexer.MIN}}},
+		OrderBy: &ast.OrderBy{Expr: &ast.Ident{Name: "Level"}, Desc: true},
+		Limit:   &ast.Limit{Limit: 10, Offset: 5},
+	}
+
+	fmt.Println(q)
+	for _, c := range ast.Conjuncts(q.Where) {
+		fmt.Println(c)
+	}
+
+	// Output:
+	// SELECT Message, PERCENTILE(Latency, 99) FROM LogEvent.TIME(10 MIN) WHERE Level > 2 AND Req.Method = 'GET' OUTPUT LAST EVERY 1 MIN ORDER BY Level DESC LIMIT 10 OFFSET 5
+	// Level > 2
+	// Req.Method = 'GET'
+}
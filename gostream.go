@@ -3,22 +3,36 @@ package gostream
 import (
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/itsubaki/gostream/ast"
 	"github.com/itsubaki/gostream/lexer"
 	"github.com/itsubaki/gostream/parser"
 	"github.com/itsubaki/gostream/stream"
 )
 
-var ErrEmptyRegistry = errors.New("type registry is empty")
+var (
+	ErrEmptyRegistry      = errors.New("type registry is empty")
+	ErrMultipleStatements = errors.New("query has multiple statements")
+)
 
 type GoStream struct {
-	opt      *Option
-	registry parser.Registry
+	opt        *Option
+	registry   parser.Registry
+	funcs      parser.Funcs
+	aggregates parser.Aggregates
 }
 
 type Option struct {
 	Verbose bool
+
+	// Tags are the struct tags that name the fields of events in queries, looked up in order,
+	// e.g. []string{"gostream", "json"}. Go field names are always accepted.
+	Tags []string
+
+	// CaseInsensitive matches the field names in queries regardless of case.
+	CaseInsensitive bool
 }
 
 func New(opt ...*Option) *GoStream {
@@ -26,7 +40,9 @@ func New(opt ...*Option) *GoStream {
 		opt: &Option{
 			Verbose: false,
 		},
-		registry: make(parser.Registry),
+		registry:   make(parser.Registry),
+		funcs:      make(parser.Funcs),
+		aggregates: make(parser.Aggregates),
 	}
 
 	if len(opt) > 0 {
@@ -41,9 +57,99 @@ func (s *GoStream) Add(typ any) *GoStream {
 	return s
 }
 
+// Func registers the Go function fn as the scalar function name to be called in queries,
+// e.g. Func("score", func(a, b float64) float64 { ... }) for SCORE(Latency, Level).
+// fn returns a value, or a value and an error for which the result is NULL.
+// It panics if name is not an identifier or fn is not such a function.
+func (s *GoStream) Func(name string, fn any) *GoStream {
+	s.funcs.Add(name, fn)
+	return s
+}
+
+// Aggregate registers fn as the aggregate function name to be called in the select list of queries,
+// e.g. P2QUANTILE(Latency, 0.9) over the values of Latency, with 0.9 passed to fn.
+// It panics if name is not an identifier.
+func (s *GoStream) Aggregate(name string, fn stream.AggregateFunc) *GoStream {
+	s.aggregates.Add(name, fn)
+	return s
+}
+
 func (s *GoStream) Query(q string) (*stream.Stream, error) {
+	p, query, err := s.parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := p.Plan(query)
+	if err != nil {
+		return nil, fmt.Errorf("plan: %w", err)
+	}
+
+	go stream.Run()
+	return stream, nil
+}
+
+// Explain returns the plan of the query without running it.
+// The placeholders in the query are bound to args as in Stmt.Query.
+func (s *GoStream) Explain(q string, args ...any) (*stream.Plan, error) {
+	p, query, err := s.parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := p.Plan(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("plan: %w", err)
+	}
+
+	return stream.Explain(), nil
+}
+
+// Stmt is a prepared query.
+// Its placeholders are bound to values each time it is run.
+type Stmt struct {
+	p     *parser.Parser
+	query *ast.Query
+}
+
+// Prepare parses the query with placeholders, ? or :name, in its where clause.
+func (s *GoStream) Prepare(q string) (*Stmt, error) {
+	p, query, err := s.parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stmt{
+		p:     p,
+		query: query,
+	}, nil
+}
+
+// Query returns a running stream of the statement with its placeholders bound to args,
+// given in order for ? and by Named for :name.
+// It returns an error if a value does not match the type of the field it is compared with.
+func (st *Stmt) Query(args ...any) (*stream.Stream, error) {
+	stream, err := st.p.Plan(st.query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+
+	go stream.Run()
+	return stream, nil
+}
+
+func (st *Stmt) String() string {
+	return st.query.String()
+}
+
+// Named returns the value of the placeholder :name.
+func Named(name string, value any) parser.NamedArg {
+	return parser.Named(name, value)
+}
+
+func (s *GoStream) parse(q string) (*parser.Parser, *ast.Query, error) {
 	if len(s.registry) == 0 {
-		return nil, ErrEmptyRegistry
+		return nil, nil, ErrEmptyRegistry
 	}
 
 	if s.opt.Verbose {
@@ -65,16 +171,100 @@ func (s *GoStream) Query(q string) (*stream.Stream, error) {
 		fmt.Println(strings.TrimRight(buf.String(), " "))
 	}
 
-	p := parser.New().Query(q)
+	p := s.parser().Query(q)
+
+	query := p.ParseQuery()
+	if len(p.Errors()) > 0 {
+		return nil, nil, fmt.Errorf("parse: %w", errors.Join(p.Errors()...))
+	}
+
+	if p.More() {
+		return nil, nil, ErrMultipleStatements
+	}
+
+	return p, query, nil
+}
+
+// StatementError is an error of the statement at Index, counted from 0, in a script.
+type StatementError struct {
+	Index int
+	Err   error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("statement %v: %v", e.Index, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// LoadScript returns the running streams of the statements in a script, in order.
+// Statements are separated by ; and comments are written after -- or between /* and */.
+// No stream runs if any statement has an error, and the errors of each statement
+// are returned as a *StatementError.
+func (s *GoStream) LoadScript(r io.Reader) ([]*stream.Stream, error) {
+	if len(s.registry) == 0 {
+		return nil, ErrEmptyRegistry
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	p := s.parser().Query(string(b))
+
+	streams, errs := make([]*stream.Stream, 0), make([]error, 0)
+	for i := 0; p.More(); i++ {
+		begin := len(p.Errors())
+		query := p.ParseQuery()
+		if len(p.Errors()) > begin {
+			errs = append(errs, &StatementError{Index: i, Err: fmt.Errorf("parse: %w", errors.Join(p.Errors()[begin:]...))})
+			continue
+		}
+
+		stream, err := p.Plan(query)
+		if err != nil {
+			errs = append(errs, &StatementError{Index: i, Err: fmt.Errorf("plan: %w", err)})
+			continue
+		}
+
+		streams = append(streams, stream)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for _, stream := range streams {
+		go stream.Run()
+	}
+
+	return streams, nil
+}
+
+// parser returns a parser of queries over the registered event types and functions.
+func (s *GoStream) parser() *parser.Parser {
+	p := parser.New(&parser.Option{
+		Verbose: s.opt.Verbose,
+		Naming: stream.Naming{
+			Tags:            s.opt.Tags,
+			CaseInsensitive: s.opt.CaseInsensitive,
+		},
+	})
+
 	for k := range s.registry {
 		p.Add(s.registry[k])
 	}
 
-	stream := p.Parse()
-	if len(p.Errors()) > 0 {
-		return nil, fmt.Errorf("parse: %v", p.Errors())
+	for k := range s.funcs {
+		p.Func(k, s.funcs[k])
 	}
 
-	go stream.Run()
-	return stream, nil
+	for k := range s.aggregates {
+		p.Aggregate(k, s.aggregates[k])
+	}
+
+	return p
 }
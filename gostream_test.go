@@ -1,11 +1,15 @@
 package gostream_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/itsubaki/gostream"
+	"github.com/itsubaki/gostream/stream"
 )
 
 func ExampleGoStream_Query() {
@@ -58,3 +62,742 @@ func TestGoStreamLength(t *testing.T) {
 		}
 	}
 }
+
+func ExampleGoStream_Query_schema() {
+	s, err := gostream.New().
+		Add(stream.NewSchema("LogEvent", map[string]any{
+			"Level":   0,
+			"Message": "",
+			"Req":     map[string]any{},
+		})).
+		Query("select Message, avg(Req.Latency) from LogEvent.length(10) where Level > 1")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	for _, in := range []string{
+		`{"type": "LogEvent", "Level": 1, "Message": "foo", "Req": {"Latency": 100}}`,
+		`{"type": "LogEvent", "Level": 2, "Message": "bar", "Req": {"Latency": 200}}`,
+		`{"type": "LogEvent", "Level": 3, "Message": "baz", "Req": {"Latency": 400}}`,
+	} {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(in), &event); err != nil {
+			fmt.Printf("unmarshal: %v", err)
+			return
+		}
+
+		s.Input() <- event
+	}
+
+	<-s.Output()
+	out := <-s.Output()
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [baz 300]
+}
+
+func TestGoStreamSchema(t *testing.T) {
+	access := stream.NewSchema("AccessEvent", map[string]any{"Path": "", "Status": 0})
+	access.Key = "kind"
+
+	g := gostream.New().
+		Add(stream.NewSchema("LogEvent", map[string]any{"Level": 0})).
+		Add(access)
+
+	logs, err := g.Query("select Level from LogEvent.length(10)")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer logs.Close()
+
+	accesses, err := g.Query("select Path, Status from AccessEvent.length(10)")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer accesses.Close()
+
+	for _, in := range []string{
+		`{"type": "LogEvent", "Level": 1}`,
+		`{"kind": "AccessEvent", "Path": "/", "Status": 200}`,
+		`{"type": "AccessEvent", "Path": "/index", "Status": 404}`,
+		`{"kind": "LogEvent", "Level": 2}`,
+		`{"Level": 3}`,
+		`{"type": "LogEvent", "Level": 4}`,
+	} {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(in), &event); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+
+		logs.Listen(event)
+		accesses.Listen(event)
+	}
+
+	cases := []struct {
+		s    *stream.Stream
+		want string
+	}{
+		{logs, "[[1] [4]]"},
+		{accesses, "[[/ 200]]"},
+	}
+
+	for _, c := range cases {
+		var last []stream.Event
+		for len(c.s.Output()) > 0 {
+			last = <-c.s.Output()
+		}
+
+		got := make([][]any, len(last))
+		for i := range last {
+			got[i] = last[i].ResultSet
+		}
+
+		if fmt.Sprint(got) != c.want {
+			t.Errorf("%v: got=%v, want=%v", c.s, got, c.want)
+		}
+	}
+}
+
+func ExampleOption_tags() {
+	type LogEvent struct {
+		Time    time.Time `json:"time"`
+		Latency int       `gostream:"latency" json:"latency_ms"`
+		Message string    `json:"message"`
+	}
+
+	s, err := gostream.
+		New(&gostream.Option{
+			Tags:            []string{"gostream", "json"},
+			CaseInsensitive: true,
+		}).
+		Add(LogEvent{}).
+		Query("select message, max(Latency) from LogEvent.length(10) where latency > 100")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	s.Input() <- LogEvent{Latency: 150, Message: "foo"}
+	out := <-s.Output()
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [foo 150]
+}
+
+func ExampleGoStream_Explain() {
+	type Request struct {
+		Method  string
+		Latency int
+	}
+
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		Req     Request
+	}
+
+	p, err := gostream.New().
+		Add(LogEvent{}).
+		Explain("select Message, avg(Req.Latency) from LogEvent.length(10) where Level > 2 order by Level desc limit 5")
+	if err != nil {
+		fmt.Printf("explain: %v", err)
+		return
+	}
+
+	fmt.Println(p)
+
+	// Output:
+	// Query: SELECT Message, AVG(Req.Latency) FROM LogEvent.LENGTH(10) WHERE Level > 2 ORDER BY Level DESC LIMIT 5
+	// Source: LogEvent (gostream_test.LogEvent)
+	// Where:
+	//   LargerThan: Level > 2
+	//     Level index=[1] type=int
+	// Window:
+	//   Length: LENGTH(10)
+	// Select:
+	//   Select: Message
+	//     Message index=[2] type=string
+	// Aggregate:
+	//   Average: AVG(Req.Latency)
+	//     Req.Latency index=[3 1] type=int
+	// OrderBy:
+	//   OrderBy: ORDER BY Level DESC
+	//     Level index=[1] type=int
+	// Limit:
+	//   Limit: LIMIT 5
+}
+
+func ExampleGoStream_Explain_json() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	p, err := gostream.New().
+		Add(LogEvent{}).
+		Explain("select count(*) from LogEvent.time(10 sec) where Level > 2")
+	if err != nil {
+		fmt.Printf("explain: %v", err)
+		return
+	}
+
+	b, err := p.JSON()
+	if err != nil {
+		fmt.Printf("json: %v", err)
+		return
+	}
+
+	fmt.Println(string(b))
+
+	// Output:
+	// {
+	//   "query": "SELECT COUNT(*) FROM LogEvent.TIME(10 SEC) WHERE Level > 2",
+	//   "source": {
+	//     "name": "LogEvent",
+	//     "type": "gostream_test.LogEvent"
+	//   },
+	//   "where": [
+	//     {
+	//       "kind": "LargerThan",
+	//       "expr": "Level > 2",
+	//       "fields": [
+	//         {
+	//           "name": "Level",
+	//           "index": [
+	//             1
+	//           ],
+	//           "type": "int"
+	//         }
+	//       ]
+	//     }
+	//   ],
+	//   "window": {
+	//     "kind": "Time",
+	//     "expr": "TIME(10 SEC)"
+	//   },
+	//   "select": [],
+	//   "aggregate": [
+	//     {
+	//       "kind": "Count",
+	//       "expr": "COUNT(*)"
+	//     }
+	//   ]
+	// }
+}
+
+func ExampleGoStream_Prepare() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	st, err := gostream.New().
+		Add(LogEvent{}).
+		Prepare("select * from LogEvent.length(10) where Level > ? and Message = :msg")
+	if err != nil {
+		fmt.Printf("prepare: %v", err)
+		return
+	}
+
+	fmt.Println(st)
+
+	for _, level := range []int{1, 2} {
+		s, err := st.Query(level, gostream.Named("msg", "foo"))
+		if err != nil {
+			fmt.Printf("query: %v", err)
+			return
+		}
+		defer s.Close()
+
+		fmt.Println(s)
+	}
+
+	if _, err := st.Query("1", gostream.Named("msg", "foo")); err != nil {
+		fmt.Println(err)
+	}
+
+	if _, err := st.Query(1); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ? AND Message = :msg
+	// SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 AND Message = 'foo'
+	// SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 2 AND Message = 'foo'
+	// bind: ?1: Level: mismatched types int and string
+	// bind: missing value for :msg
+}
+
+func TestStmtQuery(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	st, err := gostream.New().
+		Add(LogEvent{}).
+		Prepare("select * from LogEvent.length(10) where Level > ?")
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	for _, level := range []int{0, 1, 2} {
+		s, err := st.Query(level)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+
+		s.Listen(LogEvent{Time: time.Now(), Level: 1})
+		s.Listen(LogEvent{Time: time.Now(), Level: 3})
+
+		var out []stream.Event
+		for len(s.Output()) > 0 {
+			out = <-s.Output()
+		}
+
+		want := 2
+		if level > 0 {
+			want = 1
+		}
+
+		if len(out) != want {
+			t.Errorf("level=%v: len(out)=%v", level, len(out))
+		}
+
+		s.Close()
+	}
+}
+
+func ExampleGoStream_LoadScript() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	script := `
+-- errors in the last 10 events
+select * from LogEvent.length(10) where Level > 2;
+
+/* number of events per minute */
+select count(*) from LogEvent.time_batch(1 min);
+`
+
+	streams, err := gostream.New().
+		Add(LogEvent{}).
+		LoadScript(strings.NewReader(script))
+	if err != nil {
+		fmt.Printf("load: %v", err)
+		return
+	}
+
+	for _, s := range streams {
+		defer s.Close()
+		fmt.Println(s)
+	}
+
+	// Output:
+	// SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 2
+	// SELECT COUNT(*) FROM LogEvent.TIME_BATCH(1 MIN)
+}
+
+func TestLoadScriptError(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	script := `
+select * from LogEvent.length(10);
+select Nope from LogEvent.length(10);
+select * from LogEvent.length(10);
+select * from LogEvent.length(10) where Level > ?;
+`
+
+	streams, err := gostream.New().
+		Add(LogEvent{}).
+		LoadScript(strings.NewReader(script))
+	if streams != nil {
+		t.Errorf("streams=%v", streams)
+	}
+
+	var index []int
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var se *gostream.StatementError
+		if !errors.As(e, &se) {
+			t.Fatalf("unexpected error %v", e)
+		}
+
+		index = append(index, se.Index)
+	}
+
+	if fmt.Sprint(index) != "[1 3]" {
+		t.Errorf("index=%v, err=%v", index, err)
+	}
+
+	if _, err := gostream.New().Add(LogEvent{}).Query("select * from LogEvent.length(10); select * from LogEvent.length(1)"); !errors.Is(err, gostream.ErrMultipleStatements) {
+		t.Errorf("err=%v", err)
+	}
+}
+
+func TestGoStreamWhereLiteral(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		Debug   bool
+		Latency time.Duration
+	}
+
+	var cases = []struct {
+		where string
+		want  int
+	}{
+		{"Message = 'panic'", 1},
+		{"Message = \"it's\"", 1},
+		{"Level > -1 AND Level < 1e1", 3},
+		{"Debug = true", 1},
+		{"Latency > 1.5s", 1},
+		{"`Time` > TIMESTAMP '2024-01-01'", 2},
+	}
+
+	events := []LogEvent{
+		{Time: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), Level: 0, Message: "panic", Latency: 2 * time.Second},
+		{Time: time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC), Level: 1, Message: "it's", Debug: true},
+		{Time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Level: 2, Message: "ok", Latency: time.Second},
+	}
+
+	for _, c := range cases {
+		s, err := gostream.New().
+			Add(LogEvent{}).
+			Query(fmt.Sprintf("select * from LogEvent.length(10) where %v", c.where))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+
+		for _, e := range events {
+			s.Listen(e)
+		}
+
+		var out []stream.Event
+		for len(s.Output()) > 0 {
+			out = <-s.Output()
+		}
+
+		if len(out) != c.want {
+			t.Errorf("%v: want=%v, got=%v", c.where, c.want, len(out))
+		}
+
+		s.Close()
+	}
+}
+
+func ExampleGoStream_Query_func() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	s, err := gostream.New().
+		Add(LogEvent{}).
+		Query("select upper(Message), substr(Message, 1, 4), if(Level > 1, 'high', 'low'), date_trunc('hour', `Time`) from LogEvent.length(10) where length(Message) > 3")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	s.Listen(LogEvent{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Level: 2, Message: "panic"})
+	s.Listen(LogEvent{Time: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC), Level: 1, Message: "ok"})
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT UPPER(Message), SUBSTR(Message, 1, 4), IF(Level > 1, 'high', 'low'), DATE_TRUNC('hour', `Time`) FROM LogEvent.LENGTH(10) WHERE LENGTH(Message) > 3
+	// [PANIC pani high 2024-01-02 03:00:00 +0000 UTC]
+	// [PANIC pani high 2024-01-02 03:00:00 +0000 UTC]
+}
+
+func ExampleGoStream_Query_case() {
+	type Request struct {
+		Method  string
+		Latency int
+	}
+
+	s, err := gostream.New().
+		Add(Request{}).
+		Query("select case when Latency > 500 then 'slow' else 'ok' end as bucket, case Method when 'GET' then 'read' when 'POST' then 'write' end as kind from Request.length(10)")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	s.Listen(Request{Method: "GET", Latency: 800})
+	s.Listen(Request{Method: "DELETE", Latency: 20})
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT CASE WHEN Latency > 500 THEN 'slow' ELSE 'ok' END AS bucket, CASE Method WHEN 'GET' THEN 'read' WHEN 'POST' THEN 'write' END AS kind FROM Request.LENGTH(10)
+	// [slow read]
+	// [ok <nil>]
+}
+
+func ExampleGoStream_Query_pattern() {
+	type LoginFail struct {
+		User string
+	}
+
+	type LoginOK struct {
+		User string
+	}
+
+	s, err := gostream.New().
+		Add(LoginFail{}).
+		Add(LoginOK{}).
+		Query("select * from pattern [every a=LoginFail -> b=LoginOK(b.User = a.User) where timer:within(1 min)]")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	s.Listen(LoginFail{User: "alice"})
+	s.Listen(LoginFail{User: "bob"})
+	s.Listen(LoginOK{User: "bob"})
+	s.Listen(LoginOK{User: "carol"})
+	s.Listen(LoginOK{User: "alice"})
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT * FROM PATTERN [EVERY a=LoginFail -> b=LoginOK(b.User = a.User) WHERE TIMER:WITHIN(1 MIN)]
+	// [{bob} {bob}]
+	// [{alice} {alice}]
+}
+
+func ExampleGoStream_Query_matchRecognize() {
+	type Ticker struct {
+		Symbol string
+		Price  float64
+	}
+
+	s, err := gostream.New().
+		Add(Ticker{}).
+		Query("select * from Ticker.length(10) match_recognize (partition by Symbol measures A.Price as low, last(C.Price) as high pattern (A B+ C) define B as Price > prev(Price), C as Price > prev(Price))")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	s.Listen(Ticker{Symbol: "GOOG", Price: 10})
+	s.Listen(Ticker{Symbol: "MSFT", Price: 20})
+	s.Listen(Ticker{Symbol: "GOOG", Price: 11})
+	s.Listen(Ticker{Symbol: "MSFT", Price: 19})
+	s.Listen(Ticker{Symbol: "GOOG", Price: 12})
+	s.Listen(Ticker{Symbol: "GOOG", Price: 11})
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT * FROM Ticker.LENGTH(10) MATCH_RECOGNIZE (PARTITION BY Symbol MEASURES A.Price AS low, LAST(C.Price) AS high PATTERN (A B+ C) DEFINE B AS B.Price > PREV(B.Price), C AS C.Price > PREV(C.Price))
+	// [GOOG 10 12]
+}
+
+func ExampleGoStream_Query_output() {
+	type LogEvent struct {
+		Level   int
+		Message string
+	}
+
+	s, err := gostream.New().
+		Add(LogEvent{}).
+		Query("select Message, count(Level) from LogEvent.length(1000) output last every 3 events")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	for i := 0; i < 7; i++ {
+		s.Listen(LogEvent{Level: i, Message: fmt.Sprintf("foo%v", i)})
+	}
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(len(out), out[0].ResultSet)
+	}
+
+	// Output:
+	// SELECT Message, COUNT(Level) FROM LogEvent.LENGTH(1000) OUTPUT LAST EVERY 3 EVENTS
+	// 1 [foo2 3]
+	// 1 [foo5 6]
+}
+
+func ExampleGoStream_Query_irstream() {
+	type LogEvent struct {
+		Level   int
+		Message string
+	}
+
+	s, err := gostream.New().
+		Add(LogEvent{}).
+		Query("select irstream Message, sum(Level) from LogEvent.length(2)")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	for i := 1; i < 5; i++ {
+		s.Listen(LogEvent{Level: i, Message: fmt.Sprintf("foo%v", i)})
+	}
+
+	fmt.Println(s)
+	for len(s.Deltas()) > 0 {
+		d := <-s.Deltas()
+		for _, e := range d.New {
+			fmt.Println("new", e.ResultSet)
+		}
+
+		for _, e := range d.Old {
+			fmt.Println("old", e.ResultSet)
+		}
+	}
+
+	// Output:
+	// SELECT IRSTREAM Message, SUM(Level) FROM LogEvent.LENGTH(2)
+	// new [foo1 1]
+	// new [foo2 3]
+	// new [foo3 5]
+	// old [foo1]
+	// new [foo4 7]
+	// old [foo2]
+}
+
+// values is the state of the aggregate function SPREAD, the difference
+// between the largest and the smallest value in the window.
+type values []float64
+
+func (v *values) Add(x any) {
+	f, _ := x.(float64)
+	*v = append(*v, f)
+}
+
+func (v *values) Remove(x any) {
+	*v = (*v)[1:]
+}
+
+func (v *values) Result() any {
+	if len(*v) == 0 {
+		return 0.0
+	}
+
+	lo, hi := (*v)[0], (*v)[0]
+	for _, x := range *v {
+		lo, hi = min(lo, x), max(hi, x)
+	}
+
+	return hi - lo
+}
+
+func ExampleGoStream_Func() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Latency float64
+	}
+
+	s, err := gostream.New().
+		Add(LogEvent{}).
+		Func("score", func(latency, level float64) float64 { return latency * level }).
+		Aggregate("spread", func(args ...any) stream.Aggregate { return &values{} }).
+		Query("select score(Latency, Level), spread(score(Latency, Level)) from LogEvent.length(2) where score(Latency, Level) > 10")
+	if err != nil {
+		fmt.Printf("query: %v", err)
+		return
+	}
+	defer s.Close()
+
+	fmt.Println(s)
+	for _, e := range []LogEvent{{Level: 1, Latency: 5}, {Level: 2, Latency: 6}, {Level: 3, Latency: 7}, {Level: 4, Latency: 8}} {
+		s.Listen(e)
+	}
+
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT SCORE(Latency, Level), SPREAD(SCORE(Latency, Level)) FROM LogEvent.LENGTH(2) WHERE SCORE(Latency, Level) > 10
+	// [12 0]
+	// [21 9]
+	// [32 11]
+}
+
+func TestGoStreamFuncError(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	var cases = []struct {
+		query string
+		want  string
+	}{
+		{"select nope(Level) from LogEvent.length(10)", "parse: 1:8: unknown function nope"},
+		{"select * from LogEvent.length(10) where total(Level) > 1", "parse: 1:41: aggregate function TOTAL in expression"},
+		{"select score(total(Level)) from LogEvent.length(10)", "parse: 1:14: aggregate function TOTAL in expression"},
+		{"select total(Level, Level) from LogEvent.length(10)", "parse: 1:21: argument 2 of TOTAL: expected value, found Level"},
+		{"select score(Message) from LogEvent.length(10)", "parse: 1:14: argument 1 of SCORE: cannot use string as int"},
+	}
+
+	for _, c := range cases {
+		_, err := gostream.New().
+			Add(LogEvent{}).
+			Func("Score", func(v int) (int, error) { return v, nil }).
+			Aggregate("Total", func(args ...any) stream.Aggregate { return nil }).
+			Query(c.query)
+
+		var got string
+		if err != nil {
+			got = strings.Split(err.Error(), "\n")[0]
+		}
+
+		if got != c.want {
+			t.Errorf("%v: want=%v, got=%v", c.query, c.want, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 )
 
 var (
@@ -23,9 +24,35 @@ func init() {
 	}
 }
 
+// Position is a position in the input.
+// Offset is in runes from the beginning, Line and Column start at 1.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%v:%v", p.Line, p.Column)
+}
+
+// Error is an error found at the position Pos of the input.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Pos, e.Msg)
+}
+
 type Lexer struct {
 	eof    rune
 	r      *bufio.Reader
+	pos    Position
+	last   Position
+	start  Position
+	end    bool
 	errors []error
 }
 
@@ -33,6 +60,7 @@ func New(r io.Reader) *Lexer {
 	return &Lexer{
 		eof:    rune(-1),
 		r:      bufio.NewReader(r),
+		pos:    Position{Line: 1, Column: 1},
 		errors: make([]error, 0),
 	}
 }
@@ -41,8 +69,13 @@ func (l *Lexer) Errors() []error {
 	return l.errors
 }
 
+// Pos returns the position of the last scanned token.
+func (l *Lexer) Pos() Position {
+	return l.start
+}
+
 func (l *Lexer) Tokenize() (Token, string) {
-	return l.TokenizeIgnore(WHITESPACE)
+	return l.TokenizeIgnore(WHITESPACE, COMMENT)
 }
 
 func (l *Lexer) TokenizeIgnore(t ...Token) (Token, string) {
@@ -62,6 +95,7 @@ func (l *Lexer) TokenizeIgnore(t ...Token) (Token, string) {
 }
 
 func (l *Lexer) Scan() (Token, string) {
+	l.start = l.pos
 	ch := l.read()
 	if ch == l.eof {
 		return EOF, ""
@@ -72,10 +106,33 @@ func (l *Lexer) Scan() (Token, string) {
 		return l.whitespace()
 	}
 
+	if ch == '-' || ch == '+' || ch == '/' {
+		// peek, since an operator is not unread
+		var next rune
+		if b := l.peek(1); len(b) > 0 {
+			next = rune(b[0])
+		}
+
+		if (ch == '-' && next == '-') || (ch == '/' && next == '*') {
+			l.read()
+			return l.comment(ch == '/')
+		}
+
+		if ch == '-' && next == '>' {
+			l.read()
+			return ARROW, "->"
+		}
+
+		if ch != '/' && isDigit(next) {
+			return l.scanNumber(ch)
+		}
+	}
+
 	if ch == '`' {
 		str := l.scan()
 		if l.read() != '`' {
-			panic(fmt.Sprintf("invalid escape"))
+			l.errorf("unterminated escaped identifier")
+			return ILLEGAL, fmt.Sprintf("`%v", str)
 		}
 
 		return IDENT, fmt.Sprintf("`%v`", str)
@@ -85,36 +142,52 @@ func (l *Lexer) Scan() (Token, string) {
 		l.unread()
 		str := l.scan()
 
-		if strings.EqualFold(str, "order") {
-			by := l.scan()
-			if !strings.EqualFold(by, " by") {
-				panic(fmt.Sprintf("invalid suffix=%v", by))
+		if strings.EqualFold(str, "order") || strings.EqualFold(str, "partition") {
+			if isWhitespace(l.read()) {
+				l.unread()
+				l.whitespace()
+			} else {
+				l.unread()
 			}
 
-			return ORDER_BY, fmt.Sprintf("%v%v", str, by)
-		}
+			var by string
+			if isLetter(l.read()) {
+				l.unread()
+				by = l.scan()
+			} else {
+				l.unread()
+			}
 
-		if strings.EqualFold(str, "length") {
-			if l.read() == '_' {
-				batch := l.scan()
-				if !strings.EqualFold(batch, "batch") {
-					panic(fmt.Sprintf("invalid suffix=%v", batch))
-				}
+			if !strings.EqualFold(by, "by") {
+				l.errorf("expected BY after %v, found %q", str, by)
+				return ILLEGAL, str
+			}
 
-				return LENGTH_BATCH, fmt.Sprintf("%v_%v", str, batch)
+			if strings.EqualFold(str, "partition") {
+				return PARTITION_BY, fmt.Sprintf("%v %v", str, by)
 			}
-			l.unread()
+
+			return ORDER_BY, fmt.Sprintf("%v %v", str, by)
 		}
 
-		if strings.EqualFold(str, "time") {
-			if l.read() == '_' {
-				batch := l.scan()
-				if !strings.EqualFold(batch, "batch") {
-					panic(fmt.Sprintf("invalid suffix=%v", batch))
+		if strings.EqualFold(str, "timer") {
+			if l.read() == ':' {
+				var name string
+				if isLetter(l.read()) {
+					l.unread()
+					name = l.scan()
+				} else {
+					l.unread()
 				}
 
-				return TIME_BATCH, fmt.Sprintf("%v_%v", str, batch)
+				if !strings.EqualFold(name, "within") {
+					l.errorf("expected WITHIN after %v:, found %q", str, name)
+					return ILLEGAL, str
+				}
+
+				return TIMER_WITHIN, fmt.Sprintf("%v:%v", str, name)
 			}
+
 			l.unread()
 		}
 
@@ -126,19 +199,31 @@ func (l *Lexer) Scan() (Token, string) {
 	}
 
 	if isDigit(ch) {
-		l.unread()
-		return l.scanNumber()
+		return l.scanNumber(ch)
 	}
 
 	if isString(ch) {
+		return STRING, l.scanString(ch)
+	}
+
+	if ch == '?' {
+		return PARAM, string(ch)
+	}
+
+	if ch == ':' {
+		if isLetter(l.read()) {
+			l.unread()
+			return PARAM, fmt.Sprintf(":%v", l.scan())
+		}
+
 		l.unread()
-		return STRING, l.scanString()
 	}
 
 	if v, ok := operator[strings.ToLower(string(ch))]; ok {
 		return v, string(ch)
 	}
 
+	l.errorf("illegal character %q", ch)
 	return ILLEGAL, string(ch)
 }
 
@@ -146,6 +231,14 @@ func (l *Lexer) error(e error) {
 	l.errors = append(l.errors, e)
 }
 
+// errorf reports an error at the position of the token being scanned.
+func (l *Lexer) errorf(format string, a ...any) {
+	l.error(&Error{
+		Pos: l.start,
+		Msg: fmt.Sprintf(format, a...),
+	})
+}
+
 func (l *Lexer) scan() string {
 	var buf bytes.Buffer
 	if _, err := buf.WriteRune(l.read()); err != nil {
@@ -158,7 +251,7 @@ func (l *Lexer) scan() string {
 			break
 		}
 
-		if isLetter(ch) || isDigit(ch) {
+		if isLetter(ch) || isDigit(ch) || ch == '_' {
 			if _, err := buf.WriteRune(ch); err != nil {
 				l.error(err)
 			}
@@ -173,65 +266,144 @@ func (l *Lexer) scan() string {
 	return buf.String()
 }
 
-func (l *Lexer) scanString() string {
+// scanString returns the string after the opening quote up to the closing one.
+// Escape sequences \\, \', \", \n, \r and \t, and a doubled quote, are replaced with the characters they stand for.
+func (l *Lexer) scanString(quote rune) string {
 	var buf bytes.Buffer
-	if _, err := buf.WriteRune(l.read()); err != nil {
-		l.error(err)
-	}
-
 	for {
 		ch := l.read()
 		if ch == l.eof {
+			l.errorf("unterminated string")
 			break
 		}
 
-		if _, err := buf.WriteRune(ch); err != nil {
-			l.error(err)
+		if ch == quote {
+			if next := l.peek(1); len(next) == 0 || rune(next[0]) != quote {
+				break
+			}
+
+			// doubled quote
+			l.read()
 		}
 
-		if isString(ch) {
-			break
+		if ch == '\\' {
+			switch esc := l.read(); esc {
+			case '\\', '\'', '"':
+				ch = esc
+			case 'n':
+				ch = '\n'
+			case 'r':
+				ch = '\r'
+			case 't':
+				ch = '\t'
+			case l.eof:
+				l.errorf("unterminated string")
+				return buf.String()
+			default:
+				l.errorf("unknown escape sequence \\%c", esc)
+				ch = esc
+			}
+		}
+
+		if _, err := buf.WriteRune(ch); err != nil {
+			l.error(err)
 		}
 	}
 
 	return buf.String()
 }
 
-func (l *Lexer) scanNumber() (Token, string) {
+// scanNumber returns the number that starts with first, an optional sign or a digit.
+// A number is followed by a fraction and an exponent, e.g. -1.5e-3, to be a FLOAT,
+// and by units to be a DURATION as in Go, e.g. 500ms or 1h30m.
+func (l *Lexer) scanNumber(first rune) (Token, string) {
 	var buf bytes.Buffer
-	if _, err := buf.WriteRune(l.read()); err != nil {
-		l.error(err)
+	write := func() {
+		if _, err := buf.WriteRune(l.read()); err != nil {
+			l.error(err)
+		}
 	}
 
+	digits := func() {
+		for {
+			next := l.peek(1)
+			if len(next) == 0 || !isDigit(rune(next[0])) {
+				return
+			}
+
+			write()
+		}
+	}
+
+	buf.WriteRune(first)
+	digits()
+
 	token := INT
-	for {
-		ch := l.read()
-		if ch == l.eof {
-			break
+	if next := l.peek(2); len(next) == 2 && next[0] == '.' && isDigit(rune(next[1])) {
+		token = FLOAT
+		write()
+		digits()
+	}
+
+	if next := l.peek(3); len(next) > 1 && (next[0] == 'e' || next[0] == 'E') {
+		if isDigit(rune(next[1])) || (len(next) > 2 && (next[1] == '-' || next[1] == '+') && isDigit(rune(next[2]))) {
+			token = FLOAT
+			write()
+			write()
+			digits()
 		}
+	}
 
-		if ch == '.' {
-			if _, err := buf.WriteRune(ch); err != nil {
-				l.error(err)
+	// units of a duration
+	next := l.peek(64)
+	var n int
+	for n < len(next) && (isLetter(rune(next[n])) || isDigit(rune(next[n])) || next[n] == '.') {
+		n++
+	}
+
+	if n > 0 && isLetter(rune(next[0])) {
+		if _, err := time.ParseDuration(buf.String() + string(next[:n])); err == nil {
+			for i := 0; i < n; i++ {
+				write()
 			}
 
-			token = FLOAT
-			continue
+			return DURATION, buf.String()
 		}
+	}
 
-		if isDigit(ch) {
-			if _, err := buf.WriteRune(ch); err != nil {
-				l.error(err)
+	return token, buf.String()
+}
+
+// comment returns the comment after -- to the end of the line,
+// or after /* to the next */ if block is true.
+func (l *Lexer) comment(block bool) (Token, string) {
+	var buf bytes.Buffer
+	for {
+		ch := l.read()
+		if ch == l.eof {
+			if block {
+				l.errorf("unterminated comment")
 			}
 
-			continue
+			break
 		}
 
-		l.unread()
-		break
+		if !block && ch == '\n' {
+			l.unread()
+			break
+		}
+
+		if block && ch == '/' && bytes.HasSuffix(buf.Bytes(), []byte("*")) {
+			buf.Truncate(buf.Len() - 1)
+			break
+		}
+
+		if _, err := buf.WriteRune(ch); err != nil {
+			l.error(err)
+		}
 	}
 
-	return token, buf.String()
+	return COMMENT, buf.String()
 }
 
 func (l *Lexer) whitespace() (Token, string) {
@@ -264,16 +436,43 @@ func (l *Lexer) whitespace() (Token, string) {
 func (l *Lexer) read() rune {
 	ch, _, err := l.r.ReadRune()
 	if err != nil {
+		l.end = true
 		return l.eof
 	}
 
+	l.end = false
+
+	l.last = l.pos
+	l.pos.Offset++
+	l.pos.Column++
+	if ch == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	}
+
 	return ch
 }
 
+// peek returns the next n bytes without reading them, or fewer at the end of the input.
+// The last rune read cannot be unread after peek.
+func (l *Lexer) peek(n int) []byte {
+	b, _ := l.r.Peek(n)
+	return b
+}
+
 func (l *Lexer) unread() {
+	if l.end {
+		// nothing was read
+		l.end = false
+		return
+	}
+
 	if err := l.r.UnreadRune(); err != nil {
 		l.error(err)
+		return
 	}
+
+	l.pos = l.last
 }
 
 func isWhitespace(ch rune) bool {
@@ -283,7 +482,7 @@ func isWhitespace(ch rune) bool {
 	if ch == '\t' {
 		return true
 	}
-	if ch == '\n' {
+	if ch == '\n' || ch == '\r' {
 		return true
 	}
 
@@ -144,6 +144,50 @@ func TestLexer(t *testing.T) {
 				{lexer.RPAREN, ")"},
 			},
 		},
+		{
+			in: "-- errors only\nselect * /* all fields */ from LogEvent; select 1",
+			want: []Token{
+				{lexer.SELECT, "select"},
+				{lexer.ASTERISK, "*"},
+				{lexer.FROM, "from"},
+				{lexer.IDENT, "LogEvent"},
+				{lexer.SEMICOLON, ";"},
+				{lexer.SELECT, "select"},
+				{lexer.INT, "1"},
+			},
+		},
+		{
+			in: "where Level > ? and Message = :msg",
+			want: []Token{
+				{lexer.WHERE, "where"},
+				{lexer.IDENT, "Level"},
+				{lexer.LARGER, ">"},
+				{lexer.PARAM, "?"},
+				{lexer.AND, "and"},
+				{lexer.IDENT, "Message"},
+				{lexer.EQUALS, "="},
+				{lexer.PARAM, ":msg"},
+			},
+		},
+		{
+			in: "select approx_percentile(Latency, 99) from LogEvent.length(10)",
+			want: []Token{
+				{lexer.SELECT, "select"},
+				{lexer.APPROX_PERCENTILE, "approx_percentile"},
+				{lexer.LPAREN, "("},
+				{lexer.IDENT, "Latency"},
+				{lexer.COMMA, ","},
+				{lexer.INT, "99"},
+				{lexer.RPAREN, ")"},
+				{lexer.FROM, "from"},
+				{lexer.IDENT, "LogEvent"},
+				{lexer.DOT, "."},
+				{lexer.LENGTH, "length"},
+				{lexer.LPAREN, "("},
+				{lexer.INT, "10"},
+				{lexer.RPAREN, ")"},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -160,3 +204,142 @@ func TestLexer(t *testing.T) {
 		}
 	}
 }
+
+func TestLexerPos(t *testing.T) {
+	type Pos struct {
+		literal string
+		line    int
+		column  int
+	}
+
+	in := "select *\nfrom LogEvent.length(10)\n\twhere Level > 2"
+	want := []Pos{
+		{"select", 1, 1},
+		{"*", 1, 8},
+		{"from", 2, 1},
+		{"LogEvent", 2, 6},
+		{".", 2, 14},
+		{"length", 2, 15},
+		{"(", 2, 21},
+		{"10", 2, 22},
+		{")", 2, 24},
+		{"where", 3, 2},
+		{"Level", 3, 8},
+		{">", 3, 14},
+		{"2", 3, 16},
+	}
+
+	l := lexer.New(strings.NewReader(in))
+	for _, w := range want {
+		_, literal := l.Tokenize()
+		pos := l.Pos()
+		if literal != w.literal || pos.Line != w.line || pos.Column != w.column {
+			t.Errorf("want=%v@%v:%v, got=%v@%v", w.literal, w.line, w.column, literal, pos)
+		}
+	}
+}
+
+func TestLexerError(t *testing.T) {
+	var cases = []struct {
+		in   string
+		want string
+	}{
+		{"select `Level from", "1:8: unterminated escaped identifier"},
+		{"order Level", "1:1: expected BY after order, found \"Level\""},
+		{"where Message = 'x", "1:17: unterminated string"},
+		{"where Level ! 1", "1:13: illegal character '!'"},
+		{"where Level > 1 order\n  by Level", ""},
+		{"where Level > 1 order", "1:17: expected BY after order, found \"\""},
+		{"where Level > :", ""},
+		{"select * /* all", "1:10: unterminated comment"},
+		{"select * -- all", ""},
+		{"select */**/from", ""},
+		{"select - 1", "1:8: illegal character '-'"},
+		{"partition Symbol", "1:1: expected BY after partition, found \"Symbol\""},
+		{"where timer:interval(1 sec)", "1:7: expected WITHIN after timer:, found \"interval\""},
+		{`where Message = 'a\q'`, "1:17: unknown escape sequence \\q"},
+		{`where Message = 'a\`, "1:17: unterminated string"},
+	}
+
+	for _, c := range cases {
+		l := lexer.New(strings.NewReader(c.in))
+		for {
+			token, _ := l.Tokenize()
+			if token == lexer.EOF {
+				break
+			}
+		}
+
+		var got string
+		if len(l.Errors()) > 0 {
+			got = l.Errors()[0].Error()
+		}
+
+		if got != c.want {
+			t.Errorf("%v: want=%v, got=%v", c.in, c.want, got)
+		}
+	}
+}
+
+func TestLexerComment(t *testing.T) {
+	var cases = []struct {
+		in   string
+		want []string
+	}{
+		{"-- errors only\nselect", []string{" errors only", "\n", "select"}},
+		{"/* multi\nline */ select", []string{" multi\nline ", " ", "select"}},
+		{"/***/", []string{"*"}},
+	}
+
+	for _, c := range cases {
+		l := lexer.New(strings.NewReader(c.in))
+		for _, w := range c.want {
+			_, literal := l.Scan()
+			if literal != w {
+				t.Errorf("%q: want=%q, got=%q", c.in, w, literal)
+			}
+		}
+	}
+}
+
+func TestLexerLiteral(t *testing.T) {
+	type Token struct {
+		token   lexer.Token
+		literal string
+	}
+
+	var cases = []struct {
+		in   string
+		want []Token
+	}{
+		{"-5 +3 10 -0.5", []Token{{lexer.INT, "-5"}, {lexer.INT, "+3"}, {lexer.INT, "10"}, {lexer.FLOAT, "-0.5"}}},
+		{"1e6 1.5E-3 2e+2 3e", []Token{{lexer.FLOAT, "1e6"}, {lexer.FLOAT, "1.5E-3"}, {lexer.FLOAT, "2e+2"}, {lexer.INT, "3"}, {lexer.IDENT, "e"}}},
+		{"500ms 1h30m -1.5s 10 sec 10sec", []Token{{lexer.DURATION, "500ms"}, {lexer.DURATION, "1h30m"}, {lexer.DURATION, "-1.5s"}, {lexer.INT, "10"}, {lexer.SEC, "sec"}, {lexer.INT, "10"}, {lexer.SEC, "sec"}}},
+		{"10.length", []Token{{lexer.INT, "10"}, {lexer.DOT, "."}, {lexer.LENGTH, "length"}}},
+		{`'panic' "it's" 'it''s' 'it\'s' 'a\tb\nc\\'`, []Token{{lexer.STRING, "panic"}, {lexer.STRING, "it's"}, {lexer.STRING, "it's"}, {lexer.STRING, "it's"}, {lexer.STRING, "a\tb\nc\\"}}},
+		{"true FALSE null timestamp '2024-01-02'", []Token{{lexer.TRUE, "true"}, {lexer.FALSE, "FALSE"}, {lexer.NULL, "null"}, {lexer.TIMESTAMP, "timestamp"}, {lexer.STRING, "2024-01-02"}}},
+		{"case when then else end as", []Token{{lexer.CASE, "case"}, {lexer.WHEN, "when"}, {lexer.THEN, "then"}, {lexer.ELSE, "else"}, {lexer.END, "end"}, {lexer.AS, "as"}}},
+		{"pattern [every a=A -> B] timer:within timer", []Token{{lexer.PATTERN, "pattern"}, {lexer.LBRACKET, "["}, {lexer.EVERY, "every"}, {lexer.IDENT, "a"}, {lexer.EQUALS, "="}, {lexer.IDENT, "A"}, {lexer.ARROW, "->"}, {lexer.IDENT, "B"}, {lexer.RBRACKET, "]"}, {lexer.TIMER_WITHIN, "timer:within"}, {lexer.IDENT, "timer"}}},
+		{"select istream rstream irstream *", []Token{{lexer.SELECT, "select"}, {lexer.ISTREAM, "istream"}, {lexer.RSTREAM, "rstream"}, {lexer.IRSTREAM, "irstream"}, {lexer.ASTERISK, "*"}}},
+		{"output snapshot every 10 events", []Token{{lexer.OUTPUT, "output"}, {lexer.SNAPSHOT, "snapshot"}, {lexer.EVERY, "every"}, {lexer.INT, "10"}, {lexer.IDENT, "events"}}},
+		{"match_recognize (partition by Symbol measures define B+ prev", []Token{{lexer.MATCH_RECOGNIZE, "match_recognize"}, {lexer.LPAREN, "("}, {lexer.PARTITION_BY, "partition by"}, {lexer.IDENT, "Symbol"}, {lexer.MEASURES, "measures"}, {lexer.DEFINE, "define"}, {lexer.IDENT, "B"}, {lexer.PLUS, "+"}, {lexer.PREV, "prev"}}},
+	}
+
+	for _, c := range cases {
+		l := lexer.New(strings.NewReader(c.in))
+		for _, w := range c.want {
+			token, literal := l.Tokenize()
+			if token != w.token || literal != w.literal {
+				t.Errorf("%v: want=%v:%q, got=%v:%q", c.in, lexer.Tokens[w.token], w.literal, lexer.Tokens[token], literal)
+			}
+		}
+
+		if token, _ := l.Tokenize(); token != lexer.EOF {
+			t.Errorf("%v: want=EOF, got=%v", c.in, lexer.Tokens[token])
+		}
+
+		if len(l.Errors()) != 0 {
+			t.Errorf("%v: errors=%v", c.in, l.Errors())
+		}
+	}
+}
@@ -6,6 +6,7 @@ const (
 	ILLEGAL Token = iota
 	EOF
 	WHITESPACE
+	COMMENT
 	ESCAPE
 
 	literal_begin
@@ -13,6 +14,8 @@ const (
 	STRING
 	INT
 	FLOAT
+	DURATION // 500ms, 1h30m
+	PARAM    // ? or :name
 	literal_end
 
 	operator_begin
@@ -25,32 +28,77 @@ const (
 	RPAREN    // )
 	LBRACE    // {
 	RBRACE    // }
+	LBRACKET  // [
+	RBRACKET  // ]
+	ARROW     // ->
+	PLUS      // +
 	LARGER    // >
 	LESS      // <
 	EQUALS    // =
 	operator_end
 
 	keyword_begin
-	SELECT       // SELECT
-	FROM         // FROM
-	TIME         // TIME
-	LENGTH       // LENGTH
-	TIME_BATCH   // TIME_BATCH
-	LENGTH_BATCH // LENGTH_BATCH
-	SEC          // SEC
-	MIN          // MIN
-	HOUR         // HOUR
-	WHERE        // WHERE
-	ORDER_BY     // ORDER BY
-	DESC         // DESC
-	LIMIT        // LIMIT
-	OFFSET       // OFFSET
-	AVG          // AVG
-	SUM          // SUM
-	COUNT        // COUNT
-	MAX          // MAX
-	DISTINCT     // DISTINCT
+	SELECT                // SELECT
+	FROM                  // FROM
+	TIME                  // TIME
+	LENGTH                // LENGTH
+	TIME_BATCH            // TIME_BATCH
+	LENGTH_BATCH          // LENGTH_BATCH
+	SEC                   // SEC
+	MIN                   // MIN
+	HOUR                  // HOUR
+	WHERE                 // WHERE
+	AND                   // AND
+	ORDER_BY              // ORDER BY
+	DESC                  // DESC
+	LIMIT                 // LIMIT
+	OFFSET                // OFFSET
+	AVG                   // AVG
+	SUM                   // SUM
+	COUNT                 // COUNT
+	MAX                   // MAX
+	DISTINCT              // DISTINCT
+	STDDEV                // STDDEV
+	VARIANCE              // VARIANCE
+	MEDIAN                // MEDIAN
+	PERCENTILE            // PERCENTILE
+	APPROX_PERCENTILE     // APPROX_PERCENTILE
+	APPROX_COUNT_DISTINCT // APPROX_COUNT_DISTINCT
+	TOPK                  // TOPK
+	FIRST                 // FIRST
+	LAST                  // LAST
+	MAX_BY                // MAX_BY
+	MIN_BY                // MIN_BY
+	ARRAY_AGG             // ARRAY_AGG
+	TRUE                  // TRUE
+	FALSE                 // FALSE
+	NULL                  // NULL
+	TIMESTAMP             // TIMESTAMP
+	CASE                  // CASE
+	WHEN                  // WHEN
+	THEN                  // THEN
+	ELSE                  // ELSE
+	END                   // END
+	AS                    // AS
+	PATTERN               // PATTERN
+	EVERY                 // EVERY
+	TIMER_WITHIN          // TIMER:WITHIN
+	MATCH_RECOGNIZE       // MATCH_RECOGNIZE
+	PARTITION_BY          // PARTITION BY
+	MEASURES              // MEASURES
+	DEFINE                // DEFINE
+	PREV                  // PREV
+	OUTPUT                // OUTPUT
+	SNAPSHOT              // SNAPSHOT
+	ISTREAM               // ISTREAM
+	RSTREAM               // RSTREAM
+	IRSTREAM              // IRSTREAM
 	keyword_end
+
+	// units of time that are not reserved words
+	MSEC // MSEC
+	DAY  // DAY
+	WEEK // WEEK
 )
 
 var Tokens = [...]string{
@@ -58,13 +106,16 @@ var Tokens = [...]string{
 	ILLEGAL:    "ILLEGAL",
 	EOF:        "EOF",
 	WHITESPACE: "WHITESPACE",
+	COMMENT:    "COMMENT",
 	ESCAPE:     "`",
 
 	// Literals
-	IDENT:  "IDENT",
-	STRING: "STRING",
-	INT:    "INT",
-	FLOAT:  "FLOAT",
+	IDENT:    "IDENT",
+	STRING:   "STRING",
+	INT:      "INT",
+	FLOAT:    "FLOAT",
+	DURATION: "DURATION",
+	PARAM:    "PARAM",
 
 	// Operators
 	ASTERISK:  "*",
@@ -76,34 +127,88 @@ var Tokens = [...]string{
 	RPAREN:    ")",
 	LBRACE:    "{",
 	RBRACE:    "}",
+	LBRACKET:  "[",
+	RBRACKET:  "]",
+	ARROW:     "->",
+	PLUS:      "+",
 	LARGER:    ">",
 	LESS:      "<",
 	EQUALS:    "=",
 
 	// Keywords
-	SELECT:       "SELECT",
-	FROM:         "FROM",
-	TIME:         "TIME",
-	LENGTH:       "LENGTH",
-	TIME_BATCH:   "TIME_BATCH",
-	LENGTH_BATCH: "LENGTH_BATCH",
-	SEC:          "SEC",
-	MIN:          "MIN",
-	HOUR:         "HOUR",
-	WHERE:        "WHERE",
-	ORDER_BY:     "ORDER BY",
-	DESC:         "DESC",
-	LIMIT:        "LIMIT",
-	OFFSET:       "OFFSET",
-	AVG:          "AVG",
-	SUM:          "SUM",
-	COUNT:        "COUNT",
-	MAX:          "MAX",
-	DISTINCT:     "DISTINCT",
+	SELECT:                "SELECT",
+	FROM:                  "FROM",
+	TIME:                  "TIME",
+	LENGTH:                "LENGTH",
+	TIME_BATCH:            "TIME_BATCH",
+	LENGTH_BATCH:          "LENGTH_BATCH",
+	SEC:                   "SEC",
+	MIN:                   "MIN",
+	HOUR:                  "HOUR",
+	WHERE:                 "WHERE",
+	AND:                   "AND",
+	ORDER_BY:              "ORDER BY",
+	DESC:                  "DESC",
+	LIMIT:                 "LIMIT",
+	OFFSET:                "OFFSET",
+	AVG:                   "AVG",
+	SUM:                   "SUM",
+	COUNT:                 "COUNT",
+	MAX:                   "MAX",
+	DISTINCT:              "DISTINCT",
+	STDDEV:                "STDDEV",
+	VARIANCE:              "VARIANCE",
+	MEDIAN:                "MEDIAN",
+	PERCENTILE:            "PERCENTILE",
+	APPROX_PERCENTILE:     "APPROX_PERCENTILE",
+	APPROX_COUNT_DISTINCT: "APPROX_COUNT_DISTINCT",
+	TOPK:                  "TOPK",
+	FIRST:                 "FIRST",
+	LAST:                  "LAST",
+	MAX_BY:                "MAX_BY",
+	MIN_BY:                "MIN_BY",
+	ARRAY_AGG:             "ARRAY_AGG",
+	TRUE:                  "TRUE",
+	FALSE:                 "FALSE",
+	NULL:                  "NULL",
+	TIMESTAMP:             "TIMESTAMP",
+	CASE:                  "CASE",
+	WHEN:                  "WHEN",
+	THEN:                  "THEN",
+	ELSE:                  "ELSE",
+	END:                   "END",
+	AS:                    "AS",
+	PATTERN:               "PATTERN",
+	EVERY:                 "EVERY",
+	TIMER_WITHIN:          "TIMER:WITHIN",
+	MATCH_RECOGNIZE:       "MATCH_RECOGNIZE",
+	PARTITION_BY:          "PARTITION BY",
+	MEASURES:              "MEASURES",
+	DEFINE:                "DEFINE",
+	PREV:                  "PREV",
+	OUTPUT:                "OUTPUT",
+	SNAPSHOT:              "SNAPSHOT",
+	ISTREAM:               "ISTREAM",
+	RSTREAM:               "RSTREAM",
+	IRSTREAM:              "IRSTREAM",
+
+	// Units
+	MSEC: "MSEC",
+	DAY:  "DAY",
+	WEEK: "WEEK",
+}
+
+// IsKeyword reports whether token is a reserved word such as TIME or LENGTH.
+func IsKeyword(token Token) bool {
+	return token > keyword_begin && token < keyword_end
 }
 
 func IsBasicLit(token Token) bool {
-	if token == IDENT || token == STRING || token == INT || token == FLOAT {
+	if token == IDENT || token == STRING || token == INT || token == FLOAT || token == DURATION {
+		return true
+	}
+
+	if token == TRUE || token == FALSE || token == NULL {
 		return true
 	}
 
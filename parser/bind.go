@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/itsubaki/gostream/ast"
+)
+
+// NamedArg is the value of the placeholder :Name.
+type NamedArg struct {
+	Name  string
+	Value any
+}
+
+// Named returns the value of the placeholder :name.
+func Named(name string, value any) NamedArg {
+	return NamedArg{
+		Name:  name,
+		Value: value,
+	}
+}
+
+// bind returns the values of the placeholders in q given by args,
+// in order for ? and by NamedArg for :name.
+// The values are checked against the types expected where the placeholders are used,
+// as recorded by typeof when q was parsed.
+func (p *Parser) bind(q *ast.Query, args []any) (map[*ast.Param]any, error) {
+	positional, named := make([]any, 0), make(map[string]any)
+	for _, a := range args {
+		if n, ok := a.(NamedArg); ok {
+			named[n.Name] = n.Value
+			continue
+		}
+
+		positional = append(positional, a)
+	}
+
+	values, used := make(map[*ast.Param]any), make(map[string]bool)
+	var count int
+	for _, x := range ast.Params(q) {
+		v, ok := named[x.Name]
+		if x.Name == "" {
+			count++
+			v, ok = nil, x.Index < len(positional)
+			if ok {
+				v = positional[x.Index]
+			}
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("missing value for %v", label(x))
+		}
+
+		if c, ok := p.params[x]; ok {
+			if err := c(v); err != nil {
+				return nil, fmt.Errorf("%v: %v", label(x), err)
+			}
+		}
+
+		values[x], used[x.Name] = v, true
+	}
+
+	if len(positional) > count {
+		return nil, fmt.Errorf("%v values for %v placeholders", len(positional), count)
+	}
+
+	for name := range named {
+		if !used[name] {
+			return nil, fmt.Errorf("unknown placeholder :%v", name)
+		}
+	}
+
+	return values, nil
+}
+
+// label returns the placeholder x as it is written in an error message.
+// ? placeholders are numbered from 1.
+func label(x *ast.Param) string {
+	if x.Name == "" {
+		return fmt.Sprintf("?%v", x.Index+1)
+	}
+
+	return x.String()
+}
+
+// constrain records that the value of x, if x is a placeholder, must be of a type that c accepts,
+// where the placeholder is used as context, e.g. Level of Level > ?.
+func (p *Parser) constrain(x ast.Expr, context string, c check) {
+	if x, ok := x.(*ast.Param); ok {
+		p.params[x] = func(v any) error {
+			if err := c(reflect.TypeOf(v)); err != nil {
+				return fmt.Errorf("%v: %v", context, err)
+			}
+
+			return nil
+		}
+	}
+}
+
+// compare records that the value of x, if x is a placeholder, must be comparable with y of type t.
+func (p *Parser) compare(x, y ast.Expr, t reflect.Type) {
+	if x, ok := x.(*ast.Param); ok && t != nil {
+		p.params[x] = func(v any) error {
+			if err := literal(v)(t); err != nil {
+				return fmt.Errorf("%v: %v", y, err)
+			}
+
+			return nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/itsubaki/gostream/lexer"
+)
+
+// Error is an error found at the position Pos of the query.
+// Source is the line of the query that contains Pos.
+type Error struct {
+	Pos    lexer.Position
+	Msg    string
+	Source string
+}
+
+// Error returns the message followed by the offending line of the query
+// and a caret pointing at the column of the error.
+func (e *Error) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("%v: %v", e.Pos, e.Msg)
+	}
+
+	var caret strings.Builder
+	for i, r := range []rune(e.Source) {
+		if i >= e.Pos.Column-1 {
+			break
+		}
+
+		if r == '\t' {
+			caret.WriteRune('\t')
+			continue
+		}
+
+		caret.WriteRune(' ')
+	}
+
+	return fmt.Sprintf("%v: %v\n\t%v\n\t%v^", e.Pos, e.Msg, e.Source, caret.String())
+}
+
+// errorf reports an error at the position pos of the query.
+func (p *Parser) errorf(pos lexer.Position, format string, a ...any) {
+	p.error(p.annotate(pos, fmt.Sprintf(format, a...)))
+}
+
+// annotate returns an Error with the line of the query that contains pos.
+func (p *Parser) annotate(pos lexer.Position, msg string) *Error {
+	lines := strings.Split(p.query, "\n")
+
+	var source string
+	if pos.Line > 0 && pos.Line <= len(lines) {
+		source = strings.TrimRight(lines[pos.Line-1], "\r")
+	}
+
+	return &Error{
+		Pos:    pos,
+		Msg:    msg,
+		Source: source,
+	}
+}
+
+// merge adds the errors of the lexer not merged yet to the errors found since the index begin,
+// and sorts them by their position in the query.
+func (p *Parser) merge(begin int) {
+	for _, err := range p.l.Errors()[p.lexed:] {
+		var e *lexer.Error
+		if errors.As(err, &e) && p.cursor != nil && e.Pos.Offset > p.cursor.Pos.Offset {
+			// in the next statement
+			break
+		}
+
+		p.lexed++
+		if e != nil {
+			p.error(p.annotate(e.Pos, e.Msg))
+			continue
+		}
+
+		p.error(err)
+	}
+
+	offset := func(err error) int {
+		var e *Error
+		if errors.As(err, &e) {
+			return e.Pos.Offset
+		}
+
+		return -1
+	}
+
+	found := p.errors[begin:]
+	sort.SliceStable(found, func(i, j int) bool {
+		return offset(found[i]) < offset(found[j])
+	})
+}
+
+// describe returns the token t as it is written in an error message.
+func describe(t lexer.Token) string {
+	switch t {
+	case lexer.EOF:
+		return "end of query"
+	case lexer.IDENT:
+		return "identifier"
+	case lexer.STRING:
+		return "string"
+	case lexer.INT:
+		return "integer"
+	case lexer.FLOAT:
+		return "number"
+	case lexer.DURATION:
+		return "duration"
+	case lexer.TIMESTAMP:
+		return "timestamp"
+	}
+
+	return fmt.Sprintf("%q", lexer.Tokens[t])
+}
+
+// found returns the token at the cursor as it is written in an error message.
+func (p *Parser) found() string {
+	return quote(p.cursor)
+}
+
+// quote returns the token at c as it is written in an error message.
+func quote(c *Cursor) string {
+	if c.Token == lexer.EOF {
+		return describe(lexer.EOF)
+	}
+
+	return fmt.Sprintf("%q", c.Literal)
+}
@@ -1,44 +1,170 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/itsubaki/gostream/ast"
 	"github.com/itsubaki/gostream/lexer"
 	"github.com/itsubaki/gostream/stream"
 )
 
+// DefaultAccuracy is the relative accuracy of APPROX_PERCENTILE
+// when it is not given in the query.
+const DefaultAccuracy = stream.DefaultAccuracy
+
+// signature is the arguments of an aggregate function.
+// fields are the checks of its field arguments, and literals are the ranges of the
+// numeric arguments that follow them, of which the last optional ones may be omitted.
+type signature struct {
+	fields   []check
+	literals []bound
+	optional int
+}
+
+// bound checks the value of a numeric argument of an aggregate function.
+type bound func(v float64) error
+
+func percent(v float64) error {
+	if v < 0 || v > 100 {
+		return fmt.Errorf("percent must be between 0 and 100, found %v", v)
+	}
+
+	return nil
+}
+
+func positive(v float64) error {
+	if v < 1 || v != math.Trunc(v) {
+		return fmt.Errorf("k must be a positive integer, found %v", v)
+	}
+
+	return nil
+}
+
+func accuracy(v float64) error {
+	if v <= 0 || v >= 1 {
+		return fmt.Errorf("accuracy must be larger than 0 and less than 1, found %v", v)
+	}
+
+	return nil
+}
+
+var signatures = map[lexer.Token]signature{
+	lexer.AVG:                   {fields: []check{numeric}},
+	lexer.SUM:                   {fields: []check{numeric}},
+	lexer.COUNT:                 {fields: []check{nil}},
+	lexer.MAX:                   {fields: []check{ordered}},
+	lexer.MIN:                   {fields: []check{ordered}},
+	lexer.DISTINCT:              {fields: []check{nil}},
+	lexer.STDDEV:                {fields: []check{numeric}},
+	lexer.VARIANCE:              {fields: []check{numeric}},
+	lexer.MEDIAN:                {fields: []check{numeric}},
+	lexer.PERCENTILE:            {fields: []check{numeric}, literals: []bound{percent}},
+	lexer.APPROX_PERCENTILE:     {fields: []check{numeric}, literals: []bound{percent, accuracy}, optional: 1},
+	lexer.APPROX_COUNT_DISTINCT: {fields: []check{nil}},
+	lexer.TOPK:                  {fields: []check{nil}, literals: []bound{positive}},
+	lexer.FIRST:                 {fields: []check{nil}},
+	lexer.LAST:                  {fields: []check{nil}},
+	lexer.ARRAY_AGG:             {fields: []check{nil}},
+	lexer.MAX_BY:                {fields: []check{nil, ordered}},
+	lexer.MIN_BY:                {fields: []check{nil, ordered}},
+}
+
 type Cursor struct {
 	Token   lexer.Token
 	Literal string
+	Pos     lexer.Position
 }
 
 type Parser struct {
-	l        *lexer.Lexer
-	registry Registry
-	opt      *Option
-	cursor   *Cursor
-	peek     *Cursor
-	errors   []error
+	l          *lexer.Lexer
+	query      string
+	registry   Registry
+	funcs      Funcs
+	aggregates Aggregates
+	opt        *Option
+	cursor     *Cursor
+	peek       *Cursor
+	refs       []ref
+	pos        map[ast.Expr]lexer.Position
+	tags       map[string]bool
+	tag        string
+	vars       map[string]bool
+	navigation bool
+	lexed      int
+	nparam     int
+	params     map[*ast.Param]func(v any) error
+	errors     []error
 }
 
 type Option struct {
 	Verbose bool
+	Naming  stream.Naming
 }
 
 type Registry map[string]interface{}
 
+// Add registers the event type of t, a struct or a stream.Schema, by its name.
 func (r Registry) Add(t interface{}) {
+	if s, ok := t.(stream.Schema); ok {
+		r[s.Name] = s
+		return
+	}
+
 	r[reflect.TypeOf(t).Name()] = t
 }
 
+// Funcs are user-defined scalar functions by name in lower case.
+// A function is a Go function that returns a value, or a value and an error,
+// and its parameters are the arguments it is called with as in stream.Call.
+type Funcs map[string]any
+
+// Add registers the Go function fn as the scalar function name, which is case-insensitive.
+// It takes precedence over a built-in function of the same name.
+// It panics if name is not an identifier or fn is not such a function.
+func (f Funcs) Add(name string, fn any) {
+	t := reflect.TypeOf(fn)
+	if t == nil || t.Kind() != reflect.Func || t.NumOut() < 1 || t.NumOut() > 2 ||
+		(t.NumOut() == 2 && t.Out(1) != reflect.TypeOf((*error)(nil)).Elem()) {
+		panic(fmt.Sprintf("parser: function %v must return a value, or a value and an error", name))
+	}
+
+	f[funcname(name)] = fn
+}
+
+// Aggregates are user-defined aggregate functions by name in lower case.
+type Aggregates map[string]stream.AggregateFunc
+
+// Add registers fn as the aggregate function name, which is case-insensitive.
+// It takes precedence over a scalar function of the same name.
+// It panics if name is not an identifier.
+func (a Aggregates) Add(name string, fn stream.AggregateFunc) {
+	a[funcname(name)] = fn
+}
+
+// funcname returns the name of a user-defined function in lower case.
+// It panics if the name is not scanned as an identifier, e.g. a keyword such as MAX.
+func funcname(name string) string {
+	l := lexer.New(strings.NewReader(name))
+	if token, literal := l.Scan(); token != lexer.IDENT || literal != name {
+		panic(fmt.Sprintf("parser: invalid function name %q", name))
+	}
+
+	return strings.ToLower(name)
+}
+
 func New(opt ...*Option) *Parser {
 	p := &Parser{
-		registry: make(Registry),
-		errors:   make([]error, 0),
+		registry:   make(Registry),
+		funcs:      make(Funcs),
+		aggregates: make(Aggregates),
+		opt:        &Option{},
+		params:     make(map[*ast.Param]func(v any) error),
+		errors:     make([]error, 0),
 	}
 
 	if len(opt) > 0 {
@@ -57,6 +183,18 @@ func (p *Parser) Add(t interface{}) *Parser {
 	return p
 }
 
+// Func registers the Go function fn as the scalar function name as in Funcs.Add.
+func (p *Parser) Func(name string, fn any) *Parser {
+	p.funcs.Add(name, fn)
+	return p
+}
+
+// Aggregate registers fn as the aggregate function name as in Aggregates.Add.
+func (p *Parser) Aggregate(name string, fn stream.AggregateFunc) *Parser {
+	p.aggregates.Add(name, fn)
+	return p
+}
+
 func (p *Parser) error(e error) {
 	p.errors = append(p.errors, e)
 }
@@ -66,11 +204,12 @@ func (p *Parser) expect(t lexer.Token) {
 		return
 	}
 
-	p.error(fmt.Errorf(
-		"want={Token:%v, Literal: %v}, got={Token:%v, Literal: %v}",
-		t, lexer.Tokens[t],
-		p.cursor.Token, p.cursor.Literal,
-	))
+	if p.cursor.Token == lexer.ILLEGAL {
+		// already reported by the lexer
+		return
+	}
+
+	p.errorf(p.cursor.Pos, "expected %v, found %v", describe(t), p.found())
 }
 
 func (p *Parser) next() *Cursor {
@@ -80,12 +219,29 @@ func (p *Parser) next() *Cursor {
 	p.peek = &Cursor{
 		Token:   token,
 		Literal: literal,
+		Pos:     p.l.Pos(),
 	}
 
 	return p.cursor
 }
 
-func (p *Parser) length() int64 {
+// ident returns the field name at the cursor.
+// A dotted path into nested structs such as Req.Header.UserAgent is read as a whole.
+func (p *Parser) ident() string {
+	name := p.cursor.Literal
+	for p.cursor.Token == lexer.IDENT && p.peek.Token == lexer.DOT {
+		p.next()
+		p.next()
+		p.expect(lexer.IDENT)
+		name = fmt.Sprintf("%v.%v", name, p.cursor.Literal)
+	}
+
+	return name
+}
+
+// length returns the number of events of a window such as LENGTH(10), which must be positive.
+func (p *Parser) length() int {
+	kind := p.cursor.Token
 	p.next()
 	p.expect(lexer.LPAREN)
 	defer func() {
@@ -94,222 +250,908 @@ func (p *Parser) length() int64 {
 	}()
 
 	p.next()
+	v := p.integer()
+	if v <= 0 && p.cursor.Token == lexer.INT {
+		p.errorf(p.cursor.Pos, "length of %v must be positive, found %v", lexer.Tokens[kind], v)
+	}
+
+	return int(v)
+}
+
+// integer returns the integer literal at the cursor.
+func (p *Parser) integer() int64 {
 	p.expect(lexer.INT)
+	if p.cursor.Token != lexer.INT {
+		return 0
+	}
 
 	v, err := strconv.ParseInt(p.cursor.Literal, 10, 64)
 	if err != nil {
-		p.errors = append(p.errors, err)
+		p.errorf(p.cursor.Pos, "invalid integer %v: %v", p.cursor.Literal, errors.Unwrap(err))
 	}
 
 	return v
 }
 
-func (p *Parser) time() (time.Duration, lexer.Token) {
+// units are the units of time in a window by name, singular and plural.
+// The names other than SEC, MIN and HOUR are not reserved words and are scanned as identifiers.
+var units = map[string]lexer.Token{
+	"msec": lexer.MSEC, "msecs": lexer.MSEC, "millisecond": lexer.MSEC, "milliseconds": lexer.MSEC,
+	"sec": lexer.SEC, "secs": lexer.SEC, "second": lexer.SEC, "seconds": lexer.SEC,
+	"min": lexer.MIN, "mins": lexer.MIN, "minute": lexer.MIN, "minutes": lexer.MIN,
+	"hour": lexer.HOUR, "hours": lexer.HOUR,
+	"day": lexer.DAY, "days": lexer.DAY,
+	"week": lexer.WEEK, "weeks": lexer.WEEK,
+}
+
+// time returns the intervals of a time window such as (1 MIN 30 SEC) or (0.5 SEC).
+// The intervals may not be negative, and their sum must be positive.
+func (p *Parser) time() []ast.Interval {
+	kind := p.cursor.Token
 	p.next()
 	p.expect(lexer.LPAREN)
 	defer func() {
+		if p.cursor.Token == lexer.RPAREN {
+			// found instead of a unit
+			return
+		}
+
 		p.next()
 		p.expect(lexer.RPAREN)
 	}()
 
+	errs := len(p.errors)
+	v := p.number()
+	pos := p.cursor.Pos
+
+	out := p.intervals(v)
+	if len(p.errors) > errs || len(out) == 0 {
+		// already reported
+		return out
+	}
+
+	positive := (&ast.Window{Intervals: out}).Duration() > 0
+	for _, i := range out {
+		positive = positive && i.Duration() >= 0
+	}
+
+	if !positive {
+		p.errorf(pos, "length of %v must be positive, found %v", lexer.Tokens[kind], ast.Intervals(out))
+	}
+
+	return out
+}
+
+// intervals returns the length of time from the number v at the cursor such as 1 MIN 30 SEC or 0.5 SEC.
+func (p *Parser) intervals(v *ast.BasicLit) []ast.Interval {
+	out := make([]ast.Interval, 0)
+	for {
+		if p.cursor.Token != lexer.INT && p.cursor.Token != lexer.FLOAT {
+			return out
+		}
+
+		p.next()
+		u, ok := units[strings.ToLower(p.cursor.Literal)]
+		if !ok || (p.cursor.Token != lexer.IDENT && p.cursor.Token != u) {
+			if p.cursor.Token != lexer.ILLEGAL {
+				p.errorf(p.cursor.Pos, "expected time unit MSEC, SEC, MIN, HOUR, DAY or WEEK, found %v", p.found())
+			}
+
+			return out
+		}
+
+		out = append(out, ast.Interval{Value: v.Value, Unit: u})
+		if p.peek.Token != lexer.INT && p.peek.Token != lexer.FLOAT {
+			return out
+		}
+
+		v = p.number()
+	}
+}
+
+// number returns the next numeric literal.
+func (p *Parser) number() *ast.BasicLit {
 	p.next()
-	p.expect(lexer.INT)
+	if p.cursor.Token != lexer.FLOAT && p.cursor.Token != lexer.INT {
+		p.expect(lexer.FLOAT)
+		return &ast.BasicLit{Kind: lexer.INT, Value: "0"}
+	}
 
-	v, err := strconv.ParseInt(p.cursor.Literal, 10, 64)
-	if err != nil {
-		p.errors = append(p.errors, err)
+	if _, err := strconv.ParseFloat(p.cursor.Literal, 64); err != nil {
+		p.errorf(p.cursor.Pos, "invalid number %v: %v", p.cursor.Literal, errors.Unwrap(err))
+	}
+
+	return &ast.BasicLit{Kind: p.cursor.Token, Value: p.cursor.Literal}
+}
+
+// exclusive reports the clause at the cursor, which selects the events in the window,
+// if the events inserted into and expired from the window are selected by istream.
+func (p *Parser) exclusive(istream lexer.Token) {
+	if istream == lexer.ILLEGAL {
+		return
+	}
+
+	p.errorf(p.cursor.Pos, "%v cannot be used with %v", lexer.Tokens[p.cursor.Token], lexer.Tokens[istream])
+}
+
+// output returns the output clause at the cursor such as OUTPUT LAST EVERY 10 SEC or OUTPUT EVERY 100 EVENTS.
+func (p *Parser) output() *ast.Output {
+	x := &ast.Output{}
+	switch p.peek.Token {
+	case lexer.FIRST, lexer.LAST, lexer.SNAPSHOT:
+		x.Kind = p.next().Token
+	}
+
+	if p.peek.Token != lexer.EVERY {
+		if x.Kind == lexer.ILLEGAL {
+			p.next()
+			p.expect(lexer.EVERY)
+		}
+
+		return x
 	}
 
 	p.next()
-	if p.cursor.Token == lexer.SEC {
-		return time.Duration(v) * time.Second, lexer.SEC
+	v := p.number()
+	if f, _ := strconv.ParseFloat(v.Value, 64); f <= 0 && p.cursor.Token != lexer.ILLEGAL {
+		p.errorf(p.cursor.Pos, "period of OUTPUT must be positive, found %v", v.Value)
 	}
-	if p.cursor.Token == lexer.MIN {
-		return time.Duration(v) * time.Minute, lexer.MIN
+
+	if !strings.EqualFold(p.peek.Literal, "events") || p.peek.Token != lexer.IDENT {
+		x.Intervals = p.intervals(v)
+		return x
 	}
-	if p.cursor.Token == lexer.HOUR {
-		return time.Duration(v) * time.Hour, lexer.HOUR
+
+	x.Events = int(p.integer())
+	p.next()
+	return x
+}
+
+func (p *Parser) limit() *ast.Limit {
+	p.next()
+	l := &ast.Limit{Limit: int(p.integer())}
+
+	if p.peek.Token == lexer.OFFSET {
+		p.next()
+		p.next()
+		l.Offset = int(p.integer())
+	}
+
+	return l
+}
+
+// fields returns the select list. The cursor is left at FROM.
+func (p *Parser) fields() []ast.Expr {
+	fields := make([]ast.Expr, 0)
+	for p.next().Token != lexer.FROM {
+		switch p.cursor.Token {
+		case lexer.EOF, lexer.SEMICOLON:
+			p.expect(lexer.FROM)
+			return fields
+		case lexer.COMMA, lexer.ILLEGAL:
+			continue
+		case lexer.ASTERISK:
+			fields = append(fields, &ast.Star{})
+			continue
+		case lexer.IDENT, lexer.LENGTH, lexer.CASE:
+			fields = append(fields, p.alias(p.operand()))
+			continue
+		}
+
+		if _, ok := signatures[p.cursor.Token]; ok {
+			pos, x := p.cursor.Pos, p.call()
+			p.pos[x] = pos
+			fields = append(fields, p.alias(x))
+			continue
+		}
+
+		p.errorf(p.cursor.Pos, "unexpected %v in select list", p.found())
+	}
+
+	return fields
+}
+
+// alias returns x named by the AS name that follows it, or x if there is none.
+func (p *Parser) alias(x ast.Expr) ast.Expr {
+	if p.peek.Token != lexer.AS {
+		return x
 	}
 
-	return -1, lexer.EOF
+	p.next()
+	p.next()
+	p.expect(lexer.IDENT)
+
+	a := &ast.Alias{Expr: x, Name: p.cursor.Literal}
+	p.pos[a] = p.pos[x]
+	return a
 }
 
-func (p *Parser) limit() (int, int) {
+// call returns the aggregate function call at the cursor.
+func (p *Parser) call() *ast.Call {
+	fn := p.cursor.Token
+	sig := signatures[fn]
+
 	p.next()
-	p.expect(lexer.INT)
+	p.expect(lexer.LPAREN)
+
+	args := make([]ast.Expr, 0)
+	for i, c := range sig.fields {
+		if i > 0 {
+			p.next()
+			p.expect(lexer.COMMA)
+		}
+
+		p.next()
+		args = append(args, ast.Field(p.field(c)))
+	}
+
+	for i, b := range sig.literals {
+		if i >= len(sig.literals)-sig.optional && p.peek.Token != lexer.COMMA {
+			break
+		}
+
+		p.next()
+		p.expect(lexer.COMMA)
+		x := p.number()
+		args = append(args, x)
+
+		v, err := strconv.ParseFloat(x.Value, 64)
+		if err != nil {
+			continue
+		}
+
+		if err := b(v); err != nil {
+			p.errorf(p.cursor.Pos, "argument %v of %v: %v", len(args), lexer.Tokens[fn], err)
+		}
+	}
+
+	p.next()
+	p.expect(lexer.RPAREN)
+
+	return &ast.Call{Func: fn, Args: args}
+}
+
+// scalar returns the call at the cursor of a function by name,
+// a scalar function such as UPPER(Message) or a user-defined aggregate function.
+func (p *Parser) scalar() *ast.Call {
+	x := &ast.Call{Name: strings.ToLower(p.cursor.Literal), Args: make([]ast.Expr, 0)}
+	if _, ok := p.function(x.Name); !ok && p.aggregates[x.Name] == nil {
+		p.errorf(p.cursor.Pos, "unknown function %v", p.cursor.Literal)
+	}
+
+	p.next()
+	p.expect(lexer.LPAREN)
+	if p.peek.Token == lexer.RPAREN {
+		p.next()
+		return x
+	}
+
+	for {
+		p.next()
+		p.keyword()
+		x.Args = append(x.Args, p.expr())
+
+		if p.next().Token != lexer.COMMA {
+			p.expect(lexer.RPAREN)
+			return x
+		}
+	}
+}
+
+// keyword reads the reserved word at the cursor such as TIME as the escaped field of the same name,
+// where it starts an argument of a function and is not a value or a call, e.g. DATE_TRUNC('minute', Time).
+func (p *Parser) keyword() {
+	if !lexer.IsKeyword(p.cursor.Token) || p.peek.Token == lexer.LPAREN {
+		return
+	}
+
+	switch p.cursor.Token {
+	case lexer.TRUE, lexer.FALSE, lexer.NULL, lexer.TIMESTAMP, lexer.CASE:
+		return
+	}
+
+	p.cursor = &Cursor{
+		Token:   lexer.IDENT,
+		Literal: fmt.Sprintf("`%v`", p.cursor.Literal),
+		Pos:     p.cursor.Pos,
+	}
+}
+
+// function returns the scalar function of the name in lower case,
+// a user-defined one or else a built-in one.
+func (p *Parser) function(name string) (any, bool) {
+	if fn, ok := p.funcs[name]; ok {
+		return fn, true
+	}
+
+	return stream.Builtin(name)
+}
+
+// expr returns the expression at the cursor, an operand or a comparison of two operands such as Level > 2.
+func (p *Parser) expr() ast.Expr {
+	x := p.operand()
+	switch p.peek.Token {
+	case lexer.LARGER, lexer.LESS, lexer.EQUALS:
+		op := p.next().Token
+		p.next()
+
+		b := &ast.BinaryExpr{Op: op, X: x, Y: p.operand()}
+		p.pos[b] = p.pos[x]
+		return b
+	}
+
+	return x
+}
+
+// caseExpr returns the CASE expression at the cursor such as
+// CASE WHEN Latency > 500 THEN 'slow' ELSE 'ok' END, or CASE Level WHEN 1 THEN 'info' END
+// whose WHEN values are compared with the operand after CASE.
+func (p *Parser) caseExpr() *ast.Case {
+	x := &ast.Case{When: make([]ast.When, 0)}
+	if p.peek.Token != lexer.WHEN {
+		p.next()
+		x.Value = p.expr()
+	}
+
+	if p.peek.Token != lexer.WHEN {
+		p.skip(lexer.WHEN)
+		return x
+	}
+
+	for p.peek.Token == lexer.WHEN {
+		p.next()
+		p.next()
+		cond := p.expr()
+		if p.peek.Token != lexer.THEN {
+			p.skip(lexer.THEN)
+			return x
+		}
+
+		p.next()
+		p.next()
+		x.When = append(x.When, ast.When{Cond: cond, Then: p.expr()})
+	}
+
+	if p.peek.Token == lexer.ELSE {
+		p.next()
+		p.next()
+		x.Else = p.expr()
+	}
+
+	if p.peek.Token != lexer.END {
+		p.skip(lexer.END)
+		return x
+	}
+
+	p.next()
+	return x
+}
+
+// skip reports the token after the cursor found instead of t in a CASE expression,
+// and advances the cursor to the END of the expression.
+// It stops before FROM or the end of the statement if there is no END.
+func (p *Parser) skip(t lexer.Token) {
+	if p.peek.Token != lexer.ILLEGAL {
+		p.errorf(p.peek.Pos, "expected %v, found %v", describe(t), quote(p.peek))
+	}
+
+	for {
+		switch p.peek.Token {
+		case lexer.END:
+			p.next()
+			return
+		case lexer.FROM, lexer.EOF, lexer.SEMICOLON:
+			return
+		}
+
+		p.next()
+	}
+}
+
+// operand returns the field, literal, placeholder, scalar function call or CASE expression at the cursor.
+// Its position is recorded to report type errors.
+func (p *Parser) operand() ast.Expr {
+	pos := p.cursor.Pos
+
+	var x ast.Expr
+	switch {
+	case p.cursor.Token == lexer.CASE:
+		x = p.caseExpr()
+	case p.navigation && p.peek.Token == lexer.LPAREN && (p.cursor.Token == lexer.PREV || p.cursor.Token == lexer.FIRST || p.cursor.Token == lexer.LAST):
+		x = p.navigate()
+	case (p.cursor.Token == lexer.IDENT || p.cursor.Token == lexer.LENGTH) && p.peek.Token == lexer.LPAREN:
+		x = p.scalar()
+	case p.cursor.Token == lexer.IDENT:
+		x = &ast.Ident{Name: p.field(nil)}
+	default:
+		x = p.value()
+	}
+
+	p.pos[x] = pos
+	return x
+}
+
+// value returns the literal or placeholder at the cursor.
+func (p *Parser) value() ast.Expr {
+	if p.cursor.Token == lexer.PARAM {
+		x := &ast.Param{Name: strings.TrimPrefix(p.cursor.Literal, ":")}
+		if x.Name == "?" {
+			x.Name, x.Index = "", p.nparam
+			p.nparam++
+		}
+
+		return x
+	}
+
+	lit := &ast.BasicLit{Kind: p.cursor.Token, Value: p.cursor.Literal}
+	if p.cursor.Token == lexer.TIMESTAMP {
+		p.next()
+		p.expect(lexer.STRING)
+		if p.cursor.Token != lexer.STRING {
+			return lit
+		}
+
+		lit.Value = p.cursor.Literal
+	} else if !lexer.IsBasicLit(p.cursor.Token) {
+		if p.cursor.Token != lexer.ILLEGAL {
+			p.errorf(p.cursor.Pos, "expected value, found %v", p.found())
+		}
+
+		return lit
+	}
+
+	_, err := value(lit)
+	var e *strconv.NumError
+	if errors.As(err, &e) {
+		err = e.Err
+	}
 
-	l, err := strconv.Atoi(p.cursor.Literal)
 	if err != nil {
-		p.errors = append(p.errors, err)
+		p.errorf(p.cursor.Pos, "invalid %v %v: %v", describe(lit.Kind), lit.Value, err)
+	}
+
+	return lit
+}
+
+// comparison returns the next comparison such as Level > 2 or UPPER(Message) = 'GET'.
+// The left operand is a field, a scalar function call or a CASE expression.
+// A bare identifier on the right is a string literal as it is written.
+func (p *Parser) comparison() ast.Expr {
+	var x ast.Expr
+	if p.next().Token == lexer.IDENT || p.cursor.Token == lexer.LENGTH || p.cursor.Token == lexer.CASE {
+		x = p.operand()
+	} else {
+		p.expect(lexer.IDENT)
+		x = &ast.Ident{Name: p.cursor.Literal}
+	}
+
+	// >, <, =
+	op := p.next()
+	if op.Token != lexer.LARGER && op.Token != lexer.LESS && op.Token != lexer.EQUALS && op.Token != lexer.ILLEGAL {
+		p.errorf(op.Pos, "expected comparison operator, found %v", p.found())
 	}
 
 	p.next()
-	if p.cursor.Token == lexer.OFFSET {
+	if p.cursor.Token == lexer.IDENT && p.peek.Token != lexer.LPAREN {
+		pos, y := p.cursor.Pos, p.value()
+		p.pos[y] = pos
+		return &ast.BinaryExpr{Op: op.Token, X: x, Y: y}
+	}
+
+	return &ast.BinaryExpr{Op: op.Token, X: x, Y: p.operand()}
+}
+
+// pattern returns the pattern at the cursor such as
+// PATTERN [EVERY a=LoginFail -> b=LoginOK(b.User = a.User) WHERE TIMER:WITHIN(1 MIN)].
+func (p *Parser) pattern() *ast.Pattern {
+	x := &ast.Pattern{Steps: make([]ast.Step, 0)}
+
+	p.next()
+	p.expect(lexer.LBRACKET)
+	if p.peek.Token == lexer.EVERY {
 		p.next()
-		p.expect(lexer.INT)
+		x.Every = true
+	}
 
-		o, err := strconv.Atoi(p.cursor.Literal)
-		if err != nil {
-			p.errors = append(p.errors, err)
+	for {
+		p.next()
+		x.Steps = append(x.Steps, p.step())
+		if p.peek.Token != lexer.ARROW {
+			break
 		}
 
-		return l, o
+		p.next()
 	}
 
-	return l, 0
+	if p.peek.Token == lexer.WHERE {
+		p.next()
+		p.next()
+		p.expect(lexer.TIMER_WITHIN)
+		if p.cursor.Token == lexer.TIMER_WITHIN {
+			x.Within = p.time()
+		}
+	}
+
+	p.next()
+	p.expect(lexer.RBRACKET)
+	return x
 }
 
-func (p *Parser) Query(q string) *Parser {
-	p.l = lexer.New(strings.NewReader(q))
-	return p
+// step returns the event of a pattern at the cursor such as b=LoginOK(b.User = a.User).
+// The fields in its conditions that are not of a tagged event are of the event itself.
+func (p *Parser) step() ast.Step {
+	var x ast.Step
+	p.expect(lexer.IDENT)
+	if p.cursor.Token == lexer.IDENT && p.peek.Token == lexer.EQUALS {
+		x.Tag = p.cursor.Literal
+		p.next()
+		p.next()
+		p.expect(lexer.IDENT)
+	}
+
+	x.From = p.cursor.Literal
+	if _, ok := p.registry[x.From]; !ok && p.cursor.Token == lexer.IDENT {
+		p.errorf(p.cursor.Pos, "unknown event type %v", x.From)
+	}
+
+	tag, pos := x.Tag, p.cursor.Pos
+	if tag == "" {
+		tag = x.From
+	}
+
+	if p.tags[tag] {
+		p.errorf(pos, "duplicate tag %v", tag)
+	}
+	p.tags[tag] = true
+
+	if p.peek.Token != lexer.LPAREN {
+		return x
+	}
+
+	p.next()
+	p.tag = tag
+	defer func() { p.tag = "" }()
+
+	for {
+		p.next()
+		x.Where = ast.And(x.Where, p.expr())
+		if p.peek.Token != lexer.AND {
+			break
+		}
+
+		p.next()
+	}
+
+	p.next()
+	p.expect(lexer.RPAREN)
+	return x
 }
 
-func (p *Parser) Parse() *stream.Stream {
-	s := stream.New()
+// matchRecognize returns the MATCH_RECOGNIZE clause at the cursor such as
+// MATCH_RECOGNIZE (PARTITION BY Symbol MEASURES LAST(B.Price) AS high PATTERN (A B+) DEFINE B AS B.Price > PREV(B.Price)).
+// The fields in the conditions of DEFINE that are not of a variable are of the variable defined.
+func (p *Parser) matchRecognize() *ast.MatchRecognize {
+	x := &ast.MatchRecognize{
+		PartitionBy: make([]ast.Expr, 0),
+		Measures:    make([]*ast.Alias, 0),
+		Pattern:     make([]ast.Var, 0),
+		Define:      make([]ast.Define, 0),
+	}
 
-	p.next() // preload
-	for p.next().Token != lexer.EOF {
-		switch p.cursor.Token {
-		case lexer.SELECT:
-			for p.next().Token != lexer.FROM {
-				if p.cursor.Token == lexer.ASTERISK {
-					s.SelectAll()
-					continue
-				}
-
-				if p.cursor.Token == lexer.IDENT {
-					s.Select(p.cursor.Literal)
-					continue
-				}
-
-				if p.cursor.Token == lexer.AVG {
-					p.next()
-					p.expect(lexer.LPAREN)
-					s.Average(p.next().Literal)
-					p.next()
-					p.expect(lexer.RPAREN)
-					continue
-				}
-
-				if p.cursor.Token == lexer.SUM {
-					p.next()
-					p.expect(lexer.LPAREN)
-					s.Sum(p.next().Literal)
-					p.next()
-					p.expect(lexer.RPAREN)
-					continue
-				}
-
-				if p.cursor.Token == lexer.COUNT {
-					p.next()
-					p.expect(lexer.LPAREN)
-					s.Count(p.next().Literal)
-					p.next()
-					p.expect(lexer.RPAREN)
-					continue
-				}
-
-				if p.cursor.Token == lexer.MAX {
-					p.next()
-					p.expect(lexer.LPAREN)
-					s.Max(p.next().Literal)
-					p.next()
-					p.expect(lexer.RPAREN)
-					continue
-				}
-
-				if p.cursor.Token == lexer.MIN {
-					p.next()
-					p.expect(lexer.LPAREN)
-					s.Min(p.next().Literal)
-					p.next()
-					p.expect(lexer.RPAREN)
-					continue
-				}
-
-				if p.cursor.Token == lexer.DISTINCT {
-					p.next()
-					p.expect(lexer.LPAREN)
-					s.Distinct(p.next().Literal)
-					p.next()
-					p.expect(lexer.RPAREN)
-					continue
-				}
+	p.next()
+	p.expect(lexer.LPAREN)
+
+	p.navigation = true
+	defer func() { p.navigation = false }()
+
+	if p.peek.Token == lexer.PARTITION_BY {
+		p.next()
+		for {
+			p.next()
+			x.PartitionBy = append(x.PartitionBy, ast.Field(p.field(nil)))
+			if p.peek.Token != lexer.COMMA {
+				break
 			}
 
 			p.next()
-			p.expect(lexer.IDENT)
+		}
+	}
 
-			s.From(p.registry[p.cursor.Literal])
-		case lexer.LENGTH:
-			s.Length(int(p.length()))
-		case lexer.LENGTH_BATCH:
-			s.LengthBatch(int(p.length()))
-		case lexer.TIME:
-			s.Time(p.time())
-		case lexer.TIME_BATCH:
-			s.TimeBatch(p.time())
-		case lexer.ORDER_BY:
+	if p.peek.Token == lexer.ORDER_BY {
+		p.next()
+		p.next()
+		x.OrderBy = &ast.OrderBy{Expr: ast.Field(p.field(ordered))}
+		if p.peek.Token == lexer.DESC {
 			p.next()
-			p.expect(lexer.IDENT)
-			v := p.cursor.Literal
+			x.OrderBy.Desc = true
+		}
+	}
 
+	if p.peek.Token == lexer.MEASURES {
+		p.next()
+		for {
 			p.next()
-			s.OrderBy(v, p.cursor.Token == lexer.DESC)
+			m := p.expr()
 
-			// limit
-			if p.cursor.Token == lexer.DESC {
-				p.next()
+			p.next()
+			p.expect(lexer.AS)
+			p.next()
+			p.expect(lexer.IDENT)
+			x.Measures = append(x.Measures, &ast.Alias{Expr: m, Name: p.cursor.Literal})
+			if p.peek.Token != lexer.COMMA {
+				break
 			}
 
-			if p.cursor.Token != lexer.LIMIT {
-				continue
-			}
+			p.next()
+		}
+	}
 
-			s.Limit(p.limit())
-		case lexer.LIMIT:
-			s.Limit(p.limit())
-		case lexer.WHERE:
+	p.next()
+	p.expect(lexer.PATTERN)
+	p.next()
+	p.expect(lexer.LPAREN)
+	for p.peek.Token == lexer.IDENT {
+		p.next()
+		x.Pattern = append(x.Pattern, p.variable())
+	}
+
+	if len(x.Pattern) == 0 {
+		p.next()
+		p.expect(lexer.IDENT)
+	} else {
+		p.next()
+		p.expect(lexer.RPAREN)
+	}
+
+	if p.peek.Token == lexer.DEFINE {
+		p.next()
+		for {
 			p.next()
 			p.expect(lexer.IDENT)
+
 			name := p.cursor.Literal
+			if !p.vars[name] && p.cursor.Token == lexer.IDENT {
+				p.errorf(p.cursor.Pos, "unknown pattern variable %v", name)
+			}
+
+			p.next()
+			p.expect(lexer.AS)
+			p.next()
+
+			p.tag = name
+			x.Define = append(x.Define, ast.Define{Name: name, Cond: p.expr()})
+			p.tag = ""
 
-			// >, <, =
-			op := p.next()
+			if p.peek.Token != lexer.COMMA {
+				break
+			}
 
 			p.next()
-			var value interface{}
-			value = p.cursor.Literal // string
-			if p.cursor.Token == lexer.INT {
-				v, err := strconv.Atoi(p.cursor.Literal)
-				if err != nil {
-					p.errors = append(p.errors, err)
-				}
-				value = v
+		}
+	}
+
+	p.next()
+	p.expect(lexer.RPAREN)
+	return x
+}
+
+// variable returns the variable of a pattern at the cursor with its quantifier, such as B, B+, B*, B?, B{2}, B{2,} or B{2,5}.
+func (p *Parser) variable() ast.Var {
+	x := ast.Var{Name: p.cursor.Literal, Min: 1, Max: 1}
+	p.vars[x.Name], p.tags[x.Name] = true, true
+
+	switch p.peek.Token {
+	case lexer.PLUS:
+		p.next()
+		x.Max = -1
+	case lexer.ASTERISK:
+		p.next()
+		x.Min, x.Max = 0, -1
+	case lexer.PARAM:
+		p.next()
+		if p.cursor.Literal != "?" {
+			p.errorf(p.cursor.Pos, "expected quantifier, found %v", p.found())
+		}
+
+		x.Min = 0
+	case lexer.LBRACE:
+		p.next()
+		if p.peek.Token != lexer.INT && p.peek.Token != lexer.COMMA {
+			p.next()
+			p.expect(lexer.INT)
+			return x
+		}
+
+		x.Min = 0
+		if p.peek.Token == lexer.INT {
+			p.next()
+			x.Min = int(p.integer())
+			x.Max = x.Min
+		}
+
+		if p.peek.Token == lexer.COMMA {
+			p.next()
+			x.Max = -1
+			if p.peek.Token == lexer.INT {
+				p.next()
+				x.Max = int(p.integer())
 			}
+		}
+
+		p.next()
+		p.expect(lexer.RBRACE)
+		if x.Max >= 0 && (x.Max < x.Min || x.Max == 0) {
+			p.errorf(p.cursor.Pos, "invalid quantifier of %v", x)
+		}
+	}
+
+	return x
+}
+
+// navigate returns PREV, FIRST or LAST at the cursor such as PREV(B.Price).
+func (p *Parser) navigate() *ast.Call {
+	x := &ast.Call{Func: p.cursor.Token, Args: make([]ast.Expr, 0)}
+
+	p.next()
+	p.expect(lexer.LPAREN)
+	p.next()
+	x.Args = append(x.Args, p.expr())
+	p.next()
+	p.expect(lexer.RPAREN)
+	return x
+}
 
-			if p.cursor.Token == lexer.FLOAT {
-				v, err := strconv.ParseFloat(p.cursor.Literal, 64)
-				if err != nil {
-					p.errors = append(p.errors, err)
-				}
-				value = v
+// match returns the event type of the matches of the pattern x,
+// a schema with the type of the event of each step by its tag.
+func (p *Parser) match(x *ast.Pattern) any {
+	s := stream.Schema{Name: "PATTERN", Fields: make(map[string]reflect.Type)}
+	for _, st := range x.Steps {
+		tag := st.Tag
+		if tag == "" {
+			tag = st.From
+		}
+
+		t, ok := p.registry[st.From]
+		if !ok {
+			return nil
+		}
+
+		s.Fields[tag] = reflect.TypeOf(t)
+		if _, ok := t.(stream.Schema); ok {
+			s.Fields[tag] = reflect.TypeOf(map[string]any{})
+		}
+	}
+
+	return s
+}
+
+// Query sets the query to parse.
+// It may be a script of statements separated by ; that are parsed one by one with More and ParseQuery.
+func (p *Parser) Query(q string) *Parser {
+	p.l = lexer.New(strings.NewReader(q))
+	p.query = q
+	p.lexed = 0
+	p.cursor, p.peek = nil, nil
+	p.params = make(map[*ast.Param]func(v any) error)
+	return p
+}
+
+// More reports whether the query has a statement left to parse.
+// Empty statements are skipped.
+func (p *Parser) More() bool {
+	if p.peek == nil {
+		p.next() // preload
+	}
+
+	for p.peek.Token == lexer.SEMICOLON {
+		p.next()
+	}
+
+	return p.peek.Token != lexer.EOF
+}
+
+// ParseQuery returns the syntax tree of the next statement of the query.
+// The fields it references are validated against the registered event type.
+func (p *Parser) ParseQuery() *ast.Query {
+	q := &ast.Query{Fields: make([]ast.Expr, 0)}
+	p.refs = make([]ref, 0)
+	p.pos = make(map[ast.Expr]lexer.Position)
+	p.tags = make(map[string]bool)
+	p.vars = make(map[string]bool)
+	p.nparam = 0
+	begin := len(p.errors)
+
+	if p.peek == nil {
+		p.next() // preload
+	}
+
+	for p.next().Token != lexer.EOF && p.cursor.Token != lexer.SEMICOLON {
+		switch p.cursor.Token {
+		case lexer.SELECT:
+			switch p.peek.Token {
+			case lexer.ISTREAM, lexer.RSTREAM, lexer.IRSTREAM:
+				q.Stream = p.next().Token
 			}
 
-			if op.Token == lexer.LARGER {
-				s.LargerThan(name, value)
+			q.Fields = p.fields()
+			if p.cursor.Token != lexer.FROM {
+				break
 			}
 
-			if op.Token == lexer.LESS {
-				s.LessThan(name, value)
+			if p.next().Token == lexer.PATTERN {
+				q.Pattern = p.pattern()
+				break
 			}
 
-			if op.Token == lexer.EQUALS {
-				s.Equals(name, value)
+			p.expect(lexer.IDENT)
+			if p.cursor.Token != lexer.IDENT {
+				break
 			}
+
+			q.From = p.cursor.Literal
+			if _, ok := p.registry[q.From]; !ok {
+				p.errorf(p.cursor.Pos, "unknown event type %v", q.From)
+			}
+		case lexer.LENGTH, lexer.LENGTH_BATCH:
+			q.Window = &ast.Window{Kind: p.cursor.Token}
+			q.Window.Length = p.length()
+		case lexer.TIME, lexer.TIME_BATCH:
+			q.Window = &ast.Window{Kind: p.cursor.Token}
+			q.Window.Intervals = p.time()
+		case lexer.MATCH_RECOGNIZE:
+			p.exclusive(q.Stream)
+			q.MatchRecognize = p.matchRecognize()
+		case lexer.OUTPUT:
+			p.exclusive(q.Stream)
+			q.Output = p.output()
+		case lexer.ORDER_BY:
+			p.next()
+			p.expect(lexer.IDENT)
+			q.OrderBy = &ast.OrderBy{Expr: ast.Field(p.field(ordered))}
+
+			if p.peek.Token == lexer.DESC {
+				p.next()
+				q.OrderBy.Desc = true
+			}
+		case lexer.LIMIT:
+			q.Limit = p.limit()
+		case lexer.WHERE:
+			q.Where = ast.And(q.Where, p.comparison())
+			for p.peek.Token == lexer.AND {
+				p.next()
+				q.Where = ast.And(q.Where, p.comparison())
+			}
+		case lexer.DOT, lexer.ILLEGAL:
+			// between the event type and the window, or already reported by the lexer
+		default:
+			if len(p.errors) > begin {
+				// left over from an error reported in the statement
+				break
+			}
+
+			p.errorf(p.cursor.Pos, "unexpected %v", p.found())
 		}
 	}
 
+	from := p.registry[q.From]
+	if q.Pattern != nil {
+		from = p.match(q.Pattern)
+	}
+
+	p.validate(from, q)
+	p.merge(begin)
+	return q
+}
+
+// Parse returns the stream that runs the query.
+// The query may not have placeholders, which are bound by Plan.
+func (p *Parser) Parse() *stream.Stream {
+	s, err := p.Plan(p.ParseQuery())
+	if err != nil {
+		p.error(err)
+		return stream.New()
+	}
+
 	return s
 }
 
@@ -1,11 +1,14 @@
 package parser_test
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/itsubaki/gostream/parser"
+	"github.com/itsubaki/gostream/stream"
 )
 
 func ExampleParse_length() {
@@ -53,10 +56,21 @@ func ExampleParse_time() {
 }
 
 func TestParse(t *testing.T) {
+	type Header struct {
+		UserAgent string
+	}
+
+	type Request struct {
+		Header  Header
+		Time    time.Time
+		Latency int
+	}
+
 	type LogEvent struct {
 		Time    time.Time
 		Level   int
 		Message string
+		Req     *Request
 	}
 
 	var cases = []struct {
@@ -66,6 +80,10 @@ func TestParse(t *testing.T) {
 		{"SELECT DISTINCT(Level) FROM LogEvent.LENGTH(10)"},
 		{"SELECT `Time`, Level, Message FROM LogEvent.LENGTH(10)"},
 		{"SELECT AVG(Level), SUM(Level), COUNT(Level), MAX(Level), MIN(Level) FROM LogEvent.LENGTH(10)"},
+		{"SELECT STDDEV(Level), VARIANCE(Level), MEDIAN(Level) FROM LogEvent.LENGTH(10)"},
+		{"SELECT PERCENTILE(Level, 99), APPROX_PERCENTILE(Level, 99.9, 0.05) FROM LogEvent.LENGTH(10)"},
+		{"SELECT APPROX_COUNT_DISTINCT(Message), TOPK(Message, 10) FROM LogEvent.LENGTH(10)"},
+		{"SELECT FIRST(Message), LAST(Message), MAX_BY(Message, Level), MIN_BY(Message, Level), ARRAY_AGG(Level) FROM LogEvent.LENGTH(10)"},
 		{"SELECT * FROM LogEvent.LENGTH(10)"},
 		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1"},
 		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level < 1"},
@@ -75,6 +93,13 @@ func TestParse(t *testing.T) {
 		{"SELECT * FROM LogEvent.LENGTH(10) ORDER BY Level DESC"},
 		{"SELECT * FROM LogEvent.LENGTH(10) ORDER BY Level DESC LIMIT 1 OFFSET 1"},
 		{"SELECT * FROM LogEvent.LENGTH(10) ORDER BY Level LIMIT 1 OFFSET 1"},
+		{"SELECT Req.Header.UserAgent, MAX_BY(Req.`Time`, Req.Latency) FROM LogEvent.LENGTH(10) WHERE Req.Latency > 1 ORDER BY Req.Latency"},
+		{"SELECT Level, COUNT(*) FROM LogEvent.TIME(10 MIN) WHERE Level > 1 AND Message = 'x' ORDER BY Level DESC LIMIT 10"},
+		{"SELECT * FROM LogEvent.TIME(90 SEC)"},
+		{"SELECT * FROM LogEvent.TIME(1 MIN 30 SEC)"},
+		{"SELECT * FROM LogEvent.TIME(1 DAY 250 MSEC)"},
+		{"SELECT * FROM LogEvent.TIME_BATCH(0.5 SEC)"},
+		{"SELECT * FROM LogEvent.TIME_BATCH(1 WEEK)"},
 	}
 
 	p := parser.New().Add(LogEvent{})
@@ -89,3 +114,506 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseValidate(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		secret  string
+	}
+
+	var cases = []struct {
+		in   string
+		want string
+	}{
+		{"SELECT Nope FROM LogEvent.LENGTH(10)", "1:8: unknown field Nope of LogEvent"},
+		{"SELECT * FROM Unknown.LENGTH(10)", "1:15: unknown event type Unknown"},
+		{"SELECT secret FROM LogEvent.LENGTH(10)", "1:8: unknown field secret of LogEvent"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE secret = 'x'", "1:41: unknown field secret of LogEvent"},
+		{"SELECT AVG(Message) FROM LogEvent.LENGTH(10)", "1:12: Message: string is not numeric"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level = 'x'", "1:41: Level: mismatched types int and string"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Message > 1", "1:41: Message: mismatched types string and int"},
+		{"SELECT * FROM LogEvent.LENGTH(10) ORDER BY Nope", "1:44: unknown field Nope of LogEvent"},
+		{"SELECT *\nFROM LogEvent.LENGTH(10)\nWHERE Nope > 1", "3:7: unknown field Nope of LogEvent"},
+		{"SELECT MAX(`Time`), MIN(Level) FROM LogEvent.LENGTH(10) WHERE Level > 1.5", ""},
+		{"SELECT nope(Level) FROM LogEvent.LENGTH(10)", "1:8: unknown function nope"},
+		{"SELECT ABS(Message) FROM LogEvent.LENGTH(10)", "1:12: argument 1 of ABS: cannot use string as float64"},
+		{"SELECT UPPER(Message, 1) FROM LogEvent.LENGTH(10)", "1:8: wrong number of arguments to UPPER: expected 1, found 2"},
+		{"SELECT SUBSTR(Message) FROM LogEvent.LENGTH(10)", "1:8: wrong number of arguments to SUBSTR: expected at least 2, found 1"},
+		{"SELECT IF(Level, 1, 2) FROM LogEvent.LENGTH(10)", "1:11: argument 1 of IF: cannot use int as bool"},
+		{"SELECT DATE_TRUNC('minute', Time), DATE_TRUNC('days', `Time`) FROM LogEvent.LENGTH(10) WHERE DATE_TRUNC('hour', Time) > TIMESTAMP '2024-01-01 00:00:00'", ""},
+		{"SELECT DATE_TRUNC('bogus', `Time`) FROM LogEvent.LENGTH(10)", "1:19: argument 1 of DATE_TRUNC: unknown unit bogus, expected millisecond, second, minute, hour, day, week, month or year"},
+		{"SELECT DATE_TRUNC('minute', Min) FROM LogEvent.LENGTH(10)", "1:29: unknown field `Min` of LogEvent"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE UPPER(Message) = 1", "1:41: UPPER(Message): mismatched types string and int"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > LENGTH(Message) AND Message = NOW()", "1:69: Message: mismatched types string and time.Time"},
+		{"SELECT COALESCE(Level, 0), CONCAT(Message, Level, `Time`), ROUND(Level, 2), FLOOR(0.5) FROM LogEvent.LENGTH(10)", ""},
+		{"SELECT CASE WHEN Level THEN 'x' END FROM LogEvent.LENGTH(10)", "1:18: Level: cannot use int as bool"},
+		{"SELECT CASE Level WHEN 'x' THEN 1 END FROM LogEvent.LENGTH(10)", "1:24: 'x': mismatched types int and string"},
+		{"SELECT CASE WHEN Level > 1 THEN 'x' ELSE 1 END FROM LogEvent.LENGTH(10)", "1:42: 1: mismatched types string and int"},
+		{"SELECT CASE Level WHEN 1 THEN 'info' WHEN 2 THEN NULL ELSE Message END AS label FROM LogEvent.LENGTH(10)", ""},
+		{"SELECT a.Message FROM PATTERN [EVERY a=LogEvent -> b=LogEvent(Level > a.Level AND Message = a.Message)]", ""},
+		{"SELECT a.Nope FROM PATTERN [a=LogEvent -> b=LogEvent]", "1:8: unknown field a.Nope of PATTERN"},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=LogEvent(Level = a.Message)]", "1:49: b.Level: mismatched types int and string"},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=LogEvent(c.Level > 1)]", "1:49: unknown field b.c.Level of PATTERN"},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=LogEvent(Level)]", "1:49: b.Level: cannot use int as bool"},
+		{"SELECT * FROM PATTERN [a=LogEvent -> a=LogEvent]", "1:40: duplicate tag a"},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=Unknown]", "1:40: unknown event type Unknown"},
+		{"SELECT PERCENTILE(Level, -200) FROM LogEvent.LENGTH(10)", "1:26: argument 2 of PERCENTILE: percent must be between 0 and 100, found -200"},
+		{"SELECT PERCENTILE(Level, 150) FROM LogEvent.LENGTH(10)", "1:26: argument 2 of PERCENTILE: percent must be between 0 and 100, found 150"},
+		{"SELECT PERCENTILE(Level, 0), PERCENTILE(Level, 100) FROM LogEvent.LENGTH(10)", ""},
+		{"SELECT APPROX_PERCENTILE(Level, 101) FROM LogEvent.LENGTH(10)", "1:33: argument 2 of APPROX_PERCENTILE: percent must be between 0 and 100, found 101"},
+		{"SELECT APPROX_PERCENTILE(Level, 99, 0) FROM LogEvent.LENGTH(10)", "1:37: argument 3 of APPROX_PERCENTILE: accuracy must be larger than 0 and less than 1, found 0"},
+		{"SELECT APPROX_PERCENTILE(Level, 99, 1) FROM LogEvent.LENGTH(10)", "1:37: argument 3 of APPROX_PERCENTILE: accuracy must be larger than 0 and less than 1, found 1"},
+		{"SELECT APPROX_PERCENTILE(Level, 99, 0.001) FROM LogEvent.LENGTH(10)", ""},
+		{"SELECT TOPK(Level, 0) FROM LogEvent.LENGTH(10)", "1:20: argument 2 of TOPK: k must be a positive integer, found 0"},
+		{"SELECT TOPK(Level, -3) FROM LogEvent.LENGTH(10)", "1:20: argument 2 of TOPK: k must be a positive integer, found -3"},
+		{"SELECT TOPK(Level, 2.5) FROM LogEvent.LENGTH(10)", "1:20: argument 2 of TOPK: k must be a positive integer, found 2.5"},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PARTITION BY Message MEASURES A.Level AS a, LAST(B.`Time`) AS b PATTERN (A B+) DEFINE B AS Level > PREV(Level))", ""},
+		{"SELECT Level FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A))", "1:8: select list with MATCH_RECOGNIZE must be *, found Level"},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (MEASURES Level AS a PATTERN (A))", "1:61: Level: field in MEASURES is not qualified by a pattern variable"},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (MEASURES A.Nope AS a PATTERN (A))", "1:61: unknown field A.Nope of LogEvent"},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A) DEFINE A AS Level)", "1:76: A.Level: cannot use int as bool"},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A) DEFINE B AS Level > 1)", "1:71: unknown pattern variable B"},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A) DEFINE A AS Message > PREV(A.Level))", "1:76: A.Message: mismatched types string and int"},
+	}
+
+	for _, c := range cases {
+		p := parser.New().Add(LogEvent{}).Query(c.in)
+		p.Parse()
+
+		var got string
+		if len(p.Errors()) > 0 {
+			var e *parser.Error
+			if !errors.As(p.Errors()[0], &e) {
+				t.Fatalf("%v: unexpected error %v", c.in, p.Errors()[0])
+			}
+
+			got = fmt.Sprintf("%v: %v", e.Pos, e.Msg)
+		}
+
+		if got != c.want {
+			t.Errorf("%v: want=%v, got=%v", c.in, c.want, got)
+		}
+	}
+}
+
+func TestParseSyntax(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	var cases = []struct {
+		in   string
+		want []string
+	}{
+		{"SELECT * FROM LogEvent.LENGTH(10", []string{"1:33: expected \")\", found end of query"}},
+		{"SELECT * FROM LogEvent.LENGTH(x)", []string{"1:31: expected integer, found \"x\""}},
+		{"SELECT * FROM LogEvent.TIME(10 years)", []string{"1:32: expected time unit MSEC, SEC, MIN, HOUR, DAY or WEEK, found \"years\""}},
+		{"SELECT * FROM LogEvent.TIME(10)", []string{"1:31: expected time unit MSEC, SEC, MIN, HOUR, DAY or WEEK, found \")\""}},
+		{"SELECT * FROM LogEvent.TIME(sec)", []string{"1:29: expected number, found \"sec\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) ORDER Level", []string{"1:35: expected BY after ORDER, found \"Level\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Message = 'x", []string{"1:51: unterminated string"}},
+		{"SELECT `Level FROM LogEvent.LENGTH(10)", []string{"1:8: unterminated escaped identifier"}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level ! 1", []string{"1:47: illegal character '!'"}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level 1", []string{"1:47: expected comparison operator, found \"1\"", "1:48: expected value, found end of query"}},
+		{"SELECT *", []string{"1:9: expected \"FROM\", found end of query"}},
+		{"SELECT CASE WHEN Level > 1 'x' END FROM LogEvent.LENGTH(10)", []string{"1:28: expected \"THEN\", found \"x\""}},
+		{"SELECT * FROM PATTERN [a=LogEvent b=LogEvent]", []string{"1:35: expected \"]\", found \"b\""}},
+		{"SELECT * FROM PATTERN [a=LogEvent WHERE TIMER:WITHIN(1)]", []string{"1:55: expected time unit MSEC, SEC, MIN, HOUR, DAY or WEEK, found \")\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A{3,2}))", []string{"1:66: invalid quantifier of A{3,2}"}},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A{x}))", []string{"1:63: expected integer, found \"x\"", "1:64: expected \")\", found \"}\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN ())", []string{"1:61: expected identifier, found \")\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE PREV(Level) > 1", []string{"1:41: expected identifier, found \"PREV\"", "1:45: expected comparison operator, found \"(\""}},
+		{"SELECT ISTREAM * FROM LogEvent.LENGTH(10) OUTPUT LAST", []string{"1:43: OUTPUT cannot be used with ISTREAM"}},
+		{"SELECT IRSTREAM * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A))", []string{"1:44: MATCH_RECOGNIZE cannot be used with IRSTREAM"}},
+		{"SELECT * FROM LogEvent OUTPUT", []string{"1:30: expected \"EVERY\", found end of query"}},
+		{"SELECT * FROM LogEvent OUTPUT EVERY 10 foo", []string{"1:40: expected time unit MSEC, SEC, MIN, HOUR, DAY or WEEK, found \"foo\""}},
+		{"SELECT * FROM LogEvent OUTPUT EVERY 1.5 EVENTS", []string{"1:37: expected integer, found \"1.5\""}},
+		{"SELECT * FROM LogEvent OUTPUT EVERY 0 EVENTS", []string{"1:37: period of OUTPUT must be positive, found 0"}},
+		{"SELECT CASE Level END FROM LogEvent.LENGTH(10)", []string{"1:19: expected \"WHEN\", found \"END\""}},
+		{"SELECT * FROM LogEvent.LENGTH(0)", []string{"1:31: length of LENGTH must be positive, found 0"}},
+		{"SELECT * FROM LogEvent.LENGTH(-1)", []string{"1:31: length of LENGTH must be positive, found -1"}},
+		{"SELECT * FROM LogEvent.LENGTH_BATCH(0)", []string{"1:37: length of LENGTH_BATCH must be positive, found 0"}},
+		{"SELECT * FROM LogEvent.TIME(-1 SEC)", []string{"1:29: length of TIME must be positive, found -1 SEC"}},
+		{"SELECT * FROM LogEvent.TIME(1 MIN -30 SEC)", []string{"1:29: length of TIME must be positive, found 1 MIN -30 SEC"}},
+		{"SELECT * FROM LogEvent.TIME_BATCH(0.0 SEC)", []string{"1:35: length of TIME_BATCH must be positive, found 0.0 SEC"}},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=LogEvent WHERE TIMER:WITHIN(0 SEC)]", []string{"1:68: length of TIMER:WITHIN must be positive, found 0 SEC"}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 OR Level < 0", []string{"1:51: unexpected \"OR\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 foo bar", []string{"1:51: unexpected \"foo\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) LIMIT 1 2", []string{"1:43: unexpected \"2\""}},
+		{"SELECT * FROM LogEvent.LENGTH(10) )", []string{"1:35: unexpected \")\""}},
+	}
+
+	for _, c := range cases {
+		p := parser.New().Add(LogEvent{}).Query(c.in)
+		p.Parse()
+
+		got := make([]string, 0)
+		for _, err := range p.Errors() {
+			var e *parser.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("%v: unexpected error %v", c.in, err)
+			}
+
+			got = append(got, fmt.Sprintf("%v: %v", e.Pos, e.Msg))
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%v: want=%q, got=%q", c.in, c.want, got)
+		}
+	}
+}
+
+func ExampleError() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	p := parser.New().Add(LogEvent{}).Query("SELECT AVG(Message) FROM LogEvent.LENGTH(10)")
+	p.Parse()
+
+	for _, err := range p.Errors() {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// 1:12: Message: string is not numeric
+	// 	SELECT AVG(Message) FROM LogEvent.LENGTH(10)
+	// 	           ^
+}
+
+func TestParseQuery(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	var cases = []struct {
+		in   string
+		want string
+	}{
+		{"select * from LogEvent.length(10)", "SELECT * FROM LogEvent.LENGTH(10)"},
+		{"select count(*), avg(Level) from LogEvent.time_batch(1 hour)", "SELECT COUNT(*), AVG(Level) FROM LogEvent.TIME_BATCH(1 HOUR)"},
+		{"SELECT * FROM LogEvent.TIME(1 min 30 seconds)", "SELECT * FROM LogEvent.TIME(1 MIN 30 SEC)"},
+		{"SELECT * FROM LogEvent.TIME(0.5 sec)", "SELECT * FROM LogEvent.TIME(0.5 SEC)"},
+		{"SELECT * FROM LogEvent.TIME_BATCH(2 weeks 1 day 12 hours 500 msec)", "SELECT * FROM LogEvent.TIME_BATCH(2 WEEK 1 DAY 12 HOUR 500 MSEC)"},
+		{"SELECT * FROM LogEvent.TIME(10 Minutes)", "SELECT * FROM LogEvent.TIME(10 MIN)"},
+		{"SELECT Message, APPROX_PERCENTILE(Level, 99) FROM LogEvent.LENGTH_BATCH(10)", "SELECT Message, APPROX_PERCENTILE(Level, 99) FROM LogEvent.LENGTH_BATCH(10)"},
+		{"SELECT * FROM LogEvent.LENGTH(10) ORDER BY Level WHERE Level > 1", "SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 ORDER BY Level"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 WHERE Level < 1.5", "SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 AND Level < 1.5"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 AND Level < 5 AND Message = 'x'", "SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1 AND Level < 5 AND Message = 'x'"},
+		{"SELECT * FROM LogEvent.LENGTH(10) LIMIT 10 OFFSET 0", "SELECT * FROM LogEvent.LENGTH(10) LIMIT 10"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ? AND Level < ? AND Message = :msg", "SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ? AND Level < ? AND Message = :msg"},
+		{"select upper(Message), length(Message), date_trunc('minute', `Time`) from LogEvent.length(10)", "SELECT UPPER(Message), LENGTH(Message), DATE_TRUNC('minute', `Time`) FROM LogEvent.LENGTH(10)"},
+		{"select date_trunc('minute', Time) from LogEvent.length(10)", "SELECT DATE_TRUNC('minute', `Time`) FROM LogEvent.LENGTH(10)"},
+		{"SELECT IF(Level > 2, 'high', 'low') FROM LogEvent.LENGTH(10) WHERE SUBSTR(Message, 1, ?) = :prefix AND `Time` < NOW()", "SELECT IF(Level > 2, 'high', 'low') FROM LogEvent.LENGTH(10) WHERE SUBSTR(Message, 1, ?) = :prefix AND `Time` < NOW()"},
+		{"select a.Level, b.Level from pattern [every a=LogEvent -> b=LogEvent(b.Level > a.Level and Message = 'x') where timer:within(1 min 30 sec)].length(10)", "SELECT a.Level, b.Level FROM PATTERN [EVERY a=LogEvent -> b=LogEvent(b.Level > a.Level AND b.Message = 'x') WHERE TIMER:WITHIN(1 MIN 30 SEC)].LENGTH(10)"},
+		{"SELECT * FROM PATTERN [LogEvent -> b=LogEvent(Level > LogEvent.Level)] WHERE b.Level < ?", "SELECT * FROM PATTERN [LogEvent -> b=LogEvent(b.Level > LogEvent.Level)] WHERE b.Level < ?"},
+		{"select * from LogEvent.length(10) match_recognize (partition by Message order by `Time` desc measures A.Level as a, first(B.Level) as b pattern (A B+ C* D? E{2} F{2,} G{0,3}) define B as Level > prev(Level), C as C.Level < :max)", "SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PARTITION BY Message ORDER BY `Time` DESC MEASURES A.Level AS a, FIRST(B.Level) AS b PATTERN (A B+ C* D? E{2} F{2,} G{0,3}) DEFINE B AS B.Level > PREV(B.Level), C AS C.Level < :max)"},
+		{"select istream * from LogEvent.length(10)", "SELECT ISTREAM * FROM LogEvent.LENGTH(10)"},
+		{"select rstream Message, count(Level) from LogEvent.time(1 min) where Level > 2", "SELECT RSTREAM Message, COUNT(Level) FROM LogEvent.TIME(1 MIN) WHERE Level > 2"},
+		{"select irstream * from LogEvent.length_batch(10) order by Level desc limit 5", "SELECT IRSTREAM * FROM LogEvent.LENGTH_BATCH(10) ORDER BY Level DESC LIMIT 5"},
+		{"select * from LogEvent.length(1000) output every 10 sec", "SELECT * FROM LogEvent.LENGTH(1000) OUTPUT EVERY 10 SEC"},
+		{"select Level from LogEvent.length(10) output last every 100 events order by Level", "SELECT Level FROM LogEvent.LENGTH(10) OUTPUT LAST EVERY 100 EVENTS ORDER BY Level"},
+		{"select * from LogEvent output first every 1 min 30 seconds", "SELECT * FROM LogEvent OUTPUT FIRST EVERY 1 MIN 30 SEC"},
+		{"select * from LogEvent.time(1 min) output snapshot", "SELECT * FROM LogEvent.TIME(1 MIN) OUTPUT SNAPSHOT"},
+		{"select case when Level > 500 then 'slow' else 'ok' end as bucket, count(*) as n from LogEvent.length(10)", "SELECT CASE WHEN Level > 500 THEN 'slow' ELSE 'ok' END AS bucket, COUNT(*) AS n FROM LogEvent.LENGTH(10)"},
+		{"SELECT CASE Level WHEN 1 THEN 'info' WHEN 2 THEN 'warn' END FROM LogEvent.LENGTH(10) WHERE CASE WHEN Level > ? THEN 1 ELSE 0 END = 1", "SELECT CASE Level WHEN 1 THEN 'info' WHEN 2 THEN 'warn' END FROM LogEvent.LENGTH(10) WHERE CASE WHEN Level > ? THEN 1 ELSE 0 END = 1"},
+	}
+
+	p := parser.New().Add(LogEvent{})
+	for _, c := range cases {
+		q := p.Query(c.in).ParseQuery()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%v: %v", c.in, p.Errors())
+		}
+
+		got := q.String()
+		if got != c.want {
+			t.Errorf("want=%v, got=%v", c.want, got)
+		}
+
+		// the formatted query parses back to the same syntax tree
+		again := p.Query(got).ParseQuery()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%v: %v", got, p.Errors())
+		}
+
+		if !reflect.DeepEqual(q, again) {
+			t.Errorf("%v: want=%#v, got=%#v", got, q, again)
+		}
+	}
+}
+
+func ExampleParser_ParseQuery() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	p := parser.New().Add(LogEvent{})
+	q := p.Query("select Message, count(*) from LogEvent.time(10 sec) where Level > 2").ParseQuery()
+
+	fmt.Println(q.From)
+	fmt.Println(q.Window)
+	fmt.Println(q.Where)
+	fmt.Println(q)
+
+	// Output:
+	// LogEvent
+	// TIME(10 SEC)
+	// Level > 2
+	// SELECT Message, COUNT(*) FROM LogEvent.TIME(10 SEC) WHERE Level > 2
+}
+
+func TestPlanArgs(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		Ratio   float64
+	}
+
+	var cases = []struct {
+		in   string
+		args []any
+		want string
+	}{
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ?", []any{1}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ? AND Level < ?", []any{1, int64(5)}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Ratio < :max", []any{parser.Named("max", float32(0.5))}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE `Time` > :t", []any{parser.Named("t", time.Now())}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ?", []any{}, "missing value for ?1"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ?", []any{1, 2}, "2 values for 1 placeholders"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > ?", []any{1, parser.Named("x", 1)}, "unknown placeholder :x"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Message = ?", []any{1}, "?1: Message: mismatched types string and int"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE `Time` > ?", []any{1}, "?1: `Time`: mismatched types time.Time and int"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 1", []any{}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE SUBSTR(Message, 1, ?) = ?", []any{2, "pa"}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE SUBSTR(Message, 1, ?) = ?", []any{2}, "missing value for ?2"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE SUBSTR(Message, 1, ?) = ?", []any{"x", "pa"}, "?1: argument 3 of SUBSTR: cannot use string as int"},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE UPPER(Message) = ?", []any{1}, "?1: UPPER(Message): mismatched types string and int"},
+		{"SELECT UPPER(?) FROM LogEvent.LENGTH(10)", []any{1}, "?1: argument 1 of UPPER: cannot use int as string"},
+		{"SELECT CASE Level WHEN ? THEN 'x' END FROM LogEvent.LENGTH(10)", []any{"y"}, "?1: Level: mismatched types int and string"},
+		{"SELECT CASE WHEN ? THEN 'x' END FROM LogEvent.LENGTH(10)", []any{1}, "?1: condition of CASE: cannot use int as bool"},
+		{"SELECT CASE WHEN Level > 1 THEN Message ELSE ? END FROM LogEvent.LENGTH(10)", []any{1}, "?1: result of CASE: cannot use int as string"},
+		{"SELECT CASE WHEN Level > 1 THEN ? ELSE Message END FROM LogEvent.LENGTH(10)", []any{"x"}, ""},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=LogEvent(Message = ?)]", []any{1}, "?1: b.Message: mismatched types string and int"},
+		{"SELECT * FROM PATTERN [a=LogEvent -> b=LogEvent(Level > ?)]", []any{1}, ""},
+		{"SELECT * FROM LogEvent.LENGTH(10) MATCH_RECOGNIZE (PATTERN (A) DEFINE A AS Message = ?)", []any{1}, "?1: A.Message: mismatched types string and int"},
+		{"SELECT total(SUBSTR(Message, ?), 1) FROM LogEvent.LENGTH(10)", []any{"x"}, "?1: argument 2 of SUBSTR: cannot use string as int"},
+	}
+
+	p := parser.New().
+		Add(LogEvent{}).
+		Aggregate("total", func(args ...any) stream.Aggregate { return nil })
+
+	for _, c := range cases {
+		q := p.Query(c.in).ParseQuery()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%v: %v", c.in, p.Errors())
+		}
+
+		var got string
+		if _, err := p.Plan(q, c.args...); err != nil {
+			got = err.Error()
+		}
+
+		if got != c.want {
+			t.Errorf("%v: want=%v, got=%v", c.in, c.want, got)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	script := `
+-- errors
+SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 2;
+
+/* warnings
+   and errors */
+SELECT COUNT(*) FROM LogEvent.TIME(1 MIN) WHERE Level > 1;;
+SELECT Nope FROM LogEvent.LENGTH(10);
+SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 'x' -- no semicolon
+`
+
+	want := []struct {
+		query  string
+		errors []string
+	}{
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 2", nil},
+		{"SELECT COUNT(*) FROM LogEvent.TIME(1 MIN) WHERE Level > 1", nil},
+		{"SELECT Nope FROM LogEvent.LENGTH(10)", []string{"8:8: unknown field Nope of LogEvent"}},
+		{"SELECT * FROM LogEvent.LENGTH(10) WHERE Level > 'x'", []string{"9:41: Level: mismatched types int and string"}},
+	}
+
+	p := parser.New().Add(LogEvent{}).Query(script)
+
+	var i int
+	for ; p.More(); i++ {
+		begin := len(p.Errors())
+		q := p.ParseQuery()
+		if i >= len(want) {
+			t.Fatalf("too many statements: %v", q)
+		}
+
+		if q.String() != want[i].query {
+			t.Errorf("want=%v, got=%v", want[i].query, q)
+		}
+
+		got := make([]string, 0)
+		for _, err := range p.Errors()[begin:] {
+			var e *parser.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			got = append(got, fmt.Sprintf("%v: %v", e.Pos, e.Msg))
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(want[i].errors) {
+			t.Errorf("%v: want=%v, got=%v", want[i].query, want[i].errors, got)
+		}
+	}
+
+	if i != len(want) {
+		t.Errorf("want=%v statements, got=%v", len(want), i)
+	}
+}
+
+func TestParseLiteral(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		Debug   bool
+		Latency time.Duration
+		Ratio   float64
+		Req     *struct{ Method string }
+	}
+
+	var cases = []struct {
+		in   string
+		want string
+	}{
+		{"Level > -5", "Level > -5"},
+		{"Ratio < 1.5e-3", "Ratio < 0.0015"},
+		{"Debug = true", "Debug = TRUE"},
+		{"Debug = False", "Debug = FALSE"},
+		{"Latency > 1500ms", "Latency > 1.5s"},
+		{"Req = null", "Req = NULL"},
+		{"Message = 'it''s \\'panic\\''", "Message = 'it\\'s \\'panic\\''"},
+		{"Message = \"a\\tb\"", "Message = 'a\\tb'"},
+		{"`Time` > timestamp '2024-01-02 03:04:05'", "`Time` > TIMESTAMP '2024-01-02T03:04:05Z'"},
+		{"`Time` > TIMESTAMP '2024-01-02T03:04:05+09:00'", "`Time` > TIMESTAMP '2024-01-02T03:04:05+09:00'"},
+		{"`Time` < TIMESTAMP '2024-01-02'", "`Time` < TIMESTAMP '2024-01-02T00:00:00Z'"},
+	}
+
+	p := parser.New().Add(LogEvent{})
+	for _, c := range cases {
+		in := fmt.Sprintf("SELECT * FROM LogEvent.LENGTH(10) WHERE %v", c.in)
+		q := p.Query(in).ParseQuery()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%v: %v", in, p.Errors())
+		}
+
+		// the formatted query parses back to the same syntax tree
+		if again := p.Query(q.String()).ParseQuery(); !reflect.DeepEqual(q, again) {
+			t.Errorf("%v: want=%v, got=%v", in, q, again)
+		}
+
+		// the stream is built with the values of the literals
+		s, err := p.Plan(q)
+		if err != nil {
+			t.Fatalf("%v: %v", in, err)
+		}
+
+		want := fmt.Sprintf("SELECT * FROM LogEvent.LENGTH(10) WHERE %v", c.want)
+		if s.String() != want {
+			t.Errorf("want=%v, got=%v", want, s)
+		}
+	}
+}
+
+func TestParseLiteralError(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		Debug   bool
+	}
+
+	var cases = []struct {
+		in   string
+		want string
+	}{
+		{"Level = NULL", "1:41: Level: int is not nullable"},
+		{"Debug = 1", "1:41: Debug: mismatched types bool and int"},
+		{"Level = true", "1:41: Level: mismatched types int and bool"},
+		{"`Time` > TIMESTAMP 'yesterday'", "1:60: invalid timestamp yesterday: want RFC 3339 or 2006-01-02 15:04:05"},
+		{"`Time` > TIMESTAMP 1", "1:60: expected string, found \"1\""},
+		{"Level > 99999999999999999999", "1:49: invalid integer 99999999999999999999: value out of range"},
+	}
+
+	for _, c := range cases {
+		in := fmt.Sprintf("SELECT * FROM LogEvent.LENGTH(10) WHERE %v", c.in)
+		p := parser.New().Add(LogEvent{}).Query(in)
+		p.ParseQuery()
+
+		var got string
+		if len(p.Errors()) > 0 {
+			var e *parser.Error
+			if !errors.As(p.Errors()[0], &e) {
+				t.Fatalf("%v: unexpected error %v", in, p.Errors()[0])
+			}
+
+			got = fmt.Sprintf("%v: %v", e.Pos, e.Msg)
+		}
+
+		if got != c.want {
+			t.Errorf("%v: want=%v, got=%v", in, c.want, got)
+		}
+	}
+}
+
+func TestFuncsAdd(t *testing.T) {
+	var cases = []struct {
+		name  string
+		fn    any
+		panic bool
+	}{
+		{"score", func(a, b float64) float64 { return a + b }, false},
+		{"Score_2", func(v ...any) (string, error) { return "", nil }, false},
+		{"max", func() int { return 0 }, true},
+		{"2x", func() int { return 0 }, true},
+		{"a.b", func() int { return 0 }, true},
+		{"f", 1, true},
+		{"f", func() {}, true},
+		{"f", func() (int, int) { return 0, 0 }, true},
+	}
+
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if r := recover(); (r != nil) != c.panic {
+					t.Errorf("%v: want panic=%v, got=%v", c.name, c.panic, r)
+				}
+			}()
+
+			parser.New().Func(c.name, c.fn)
+		}()
+	}
+}
@@ -0,0 +1,345 @@
+package parser
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+	"github.com/itsubaki/gostream/stream"
+)
+
+// Plan returns the stream that runs the query q over the registered event type.
+// q is expected to be valid, as returned by ParseQuery without errors.
+// args are the values of the placeholders in q, given in order for ? and by NamedArg for :name.
+func (p *Parser) Plan(q *ast.Query, args ...any) (*stream.Stream, error) {
+	values, err := p.bind(q, args)
+	if err != nil {
+		return nil, err
+	}
+
+	s := stream.New().Naming(p.opt.Naming)
+	switch q.Stream {
+	case lexer.ISTREAM:
+		s.IStream()
+	case lexer.RSTREAM:
+		s.RStream()
+	case lexer.IRSTREAM:
+		s.IRStream()
+	}
+
+	for _, f := range q.Fields {
+		var name string
+		if a, ok := f.(*ast.Alias); ok {
+			f, name = a.Expr, a.Name
+		}
+
+		switch x := f.(type) {
+		case *ast.Star:
+			s.SelectAll()
+		case *ast.Ident:
+			s.Select(x.Name)
+		case *ast.Case:
+			s.SelectExpr(p.compile(x, values))
+		case *ast.Call:
+			fn, ok := p.aggregates[x.Name]
+			switch {
+			case ok:
+				args := make([]any, 0)
+				for _, a := range x.Args[1:] {
+					args = append(args, p.compile(a, values).Eval(nil))
+				}
+
+				s.Aggregate(x.Name, fn, p.compile(x.Args[0], values), args...)
+			case x.Name != "":
+				s.SelectExpr(p.compile(x, values))
+			default:
+				aggregate(s, x)
+			}
+		}
+
+		if name != "" {
+			s.As(name)
+		}
+	}
+
+	if x := q.Pattern; x != nil {
+		pt := &stream.Pattern{
+			Every:  x.Every,
+			Steps:  make([]stream.PatternStep, len(x.Steps)),
+			Within: x.Duration(),
+			Unit:   unit(x.Within),
+		}
+
+		for i, st := range x.Steps {
+			pt.Steps[i] = stream.PatternStep{Tag: st.Tag, From: p.registry[st.From]}
+			if st.Where == nil {
+				continue
+			}
+
+			for _, c := range ast.Conjuncts(st.Where) {
+				pt.Steps[i].Where = append(pt.Steps[i].Where, p.compile(c, values))
+			}
+		}
+
+		s.Pattern(pt)
+	} else {
+		s.From(p.registry[q.From])
+	}
+
+	if w := q.Window; w != nil {
+		switch w.Kind {
+		case lexer.LENGTH:
+			s.Length(w.Length)
+		case lexer.LENGTH_BATCH:
+			s.LengthBatch(w.Length)
+		case lexer.TIME:
+			s.Time(w.Duration(), unit(w.Intervals))
+		case lexer.TIME_BATCH:
+			s.TimeBatch(w.Duration(), unit(w.Intervals))
+		}
+	}
+
+	if m := q.MatchRecognize; m != nil {
+		s.MatchRecognize(p.recognizer(m, values))
+	}
+
+	if q.Where != nil {
+		for _, c := range ast.Conjuncts(q.Where) {
+			x, ok := c.(*ast.BinaryExpr)
+			if !ok {
+				continue
+			}
+
+			// a field compared with a value
+			id, ok := x.X.(*ast.Ident)
+
+			var v any
+			switch y := x.Y.(type) {
+			case *ast.BasicLit:
+				v, _ = value(y)
+			case *ast.Param:
+				v = values[y]
+			default:
+				ok = false
+			}
+
+			if !ok {
+				s.Compare(p.compile(x.X, values), x.Op, p.compile(x.Y, values))
+				continue
+			}
+
+			name := id.Name
+			switch x.Op {
+			case lexer.LARGER:
+				s.LargerThan(name, v)
+			case lexer.LESS:
+				s.LessThan(name, v)
+			case lexer.EQUALS:
+				s.Equals(name, v)
+			}
+		}
+	}
+
+	if q.OrderBy != nil {
+		s.OrderBy(q.OrderBy.Expr.String(), q.OrderBy.Desc)
+	}
+
+	if q.Limit != nil {
+		s.Limit(q.Limit.Limit, q.Limit.Offset)
+	}
+
+	if o := q.Output; o != nil {
+		s.Rate(&stream.Rate{Kind: o.Kind, Events: o.Events, Every: o.Duration(), Unit: unit(o.Intervals)})
+	}
+
+	return s, nil
+}
+
+// compile returns the expression x evaluated on events,
+// with the placeholders replaced with their values.
+func (p *Parser) compile(x ast.Expr, values map[*ast.Param]any) stream.Expr {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return &stream.Field{Name: x.Name}
+	case *ast.BasicLit:
+		v, _ := value(x)
+		return &stream.Value{Value: v}
+	case *ast.Param:
+		return &stream.Value{Value: values[x]}
+	case *ast.Call:
+		if x.Name == "" && len(x.Args) == 1 {
+			// PREV, FIRST or LAST in MATCH_RECOGNIZE
+			return &stream.Navigate{Func: x.Func, X: p.compile(x.Args[0], values)}
+		}
+
+		fn, _ := p.function(x.Name)
+		args := make([]stream.Expr, len(x.Args))
+		for i, a := range x.Args {
+			args[i] = p.compile(a, values)
+		}
+
+		return &stream.Call{Name: x.Name, Func: fn, Args: args}
+	case *ast.BinaryExpr:
+		return &stream.Compare{Op: x.Op, X: p.compile(x.X, values), Y: p.compile(x.Y, values)}
+	case *ast.Case:
+		c := &stream.Case{When: make([]stream.When, len(x.When))}
+		if x.Value != nil {
+			c.Value = p.compile(x.Value, values)
+		}
+
+		for i, w := range x.When {
+			c.When[i] = stream.When{Cond: p.compile(w.Cond, values), Then: p.compile(w.Then, values)}
+		}
+
+		if x.Else != nil {
+			c.Else = p.compile(x.Else, values)
+		}
+
+		return c
+	}
+
+	return &stream.Value{}
+}
+
+// recognizer returns the row pattern matching of the MATCH_RECOGNIZE clause x.
+func (p *Parser) recognizer(x *ast.MatchRecognize, values map[*ast.Param]any) *stream.MatchRecognize {
+	out := &stream.MatchRecognize{
+		PartitionBy: make([]*stream.Field, len(x.PartitionBy)),
+		Measures:    make([]stream.Measure, len(x.Measures)),
+		Pattern:     make([]stream.Var, len(x.Pattern)),
+		Define:      make([]stream.Define, len(x.Define)),
+	}
+
+	for i, f := range x.PartitionBy {
+		out.PartitionBy[i] = &stream.Field{Name: f.String()}
+	}
+
+	if x.OrderBy != nil {
+		out.OrderBy = &stream.OrderBy{Name: x.OrderBy.Expr.String(), Desc: x.OrderBy.Desc}
+	}
+
+	for i, m := range x.Measures {
+		out.Measures[i] = stream.Measure{Name: m.Name, Expr: p.compile(m.Expr, values)}
+	}
+
+	for i, v := range x.Pattern {
+		out.Pattern[i] = stream.Var{Name: v.Name, Min: v.Min, Max: v.Max}
+	}
+
+	for i, d := range x.Define {
+		out.Define[i] = stream.Define{Name: d.Name, Cond: p.compile(d.Cond, values)}
+	}
+
+	return out
+}
+
+// aggregate adds the aggregate function call x to s.
+func aggregate(s *stream.Stream, x *ast.Call) {
+	name := x.Args[0].String()
+	switch x.Func {
+	case lexer.AVG:
+		s.Average(name)
+	case lexer.SUM:
+		s.Sum(name)
+	case lexer.COUNT:
+		s.Count(name)
+	case lexer.MAX:
+		s.Max(name)
+	case lexer.MIN:
+		s.Min(name)
+	case lexer.DISTINCT:
+		s.Distinct(name)
+	case lexer.STDDEV:
+		s.StdDev(name)
+	case lexer.VARIANCE:
+		s.Variance(name)
+	case lexer.MEDIAN:
+		s.Median(name)
+	case lexer.PERCENTILE:
+		s.Percentile(name, float(x.Args[1]))
+	case lexer.APPROX_PERCENTILE:
+		accuracy := DefaultAccuracy
+		if len(x.Args) > 2 {
+			accuracy = float(x.Args[2])
+		}
+		s.ApproxPercentile(name, float(x.Args[1]), accuracy)
+	case lexer.APPROX_COUNT_DISTINCT:
+		s.ApproxCountDistinct(name)
+	case lexer.TOPK:
+		s.TopK(name, int(float(x.Args[1])))
+	case lexer.FIRST:
+		s.First(name)
+	case lexer.LAST:
+		s.Last(name)
+	case lexer.ARRAY_AGG:
+		s.ArrayAgg(name)
+	case lexer.MAX_BY:
+		s.MaxBy(name, x.Args[1].String())
+	case lexer.MIN_BY:
+		s.MinBy(name, x.Args[1].String())
+	}
+}
+
+var errInvalidTimestamp = errors.New("want RFC 3339 or 2006-01-02 15:04:05")
+
+// layouts are the layouts of TIMESTAMP literals.
+// A timestamp without a time zone is in UTC.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// value returns the literal as an int, a float64, a bool, a time.Duration, a time.Time,
+// nil for NULL, or a string.
+func value(lit *ast.BasicLit) (any, error) {
+	switch lit.Kind {
+	case lexer.INT:
+		return strconv.Atoi(lit.Value)
+	case lexer.FLOAT:
+		return strconv.ParseFloat(lit.Value, 64)
+	case lexer.TRUE:
+		return true, nil
+	case lexer.FALSE:
+		return false, nil
+	case lexer.NULL:
+		return nil, nil
+	case lexer.DURATION:
+		return time.ParseDuration(lit.Value)
+	case lexer.TIMESTAMP:
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, lit.Value); err == nil {
+				return t, nil
+			}
+		}
+
+		return nil, errInvalidTimestamp
+	}
+
+	return lit.Value, nil
+}
+
+// float returns the numeric literal x as a float64.
+func float(x ast.Expr) float64 {
+	lit, ok := x.(*ast.BasicLit)
+	if !ok {
+		return 0
+	}
+
+	v, _ := strconv.ParseFloat(lit.Value, 64)
+	return v
+}
+
+// unit returns the unit of a length of time written in a single unit,
+// or ILLEGAL for it to be written in the largest units.
+func unit(intervals []ast.Interval) lexer.Token {
+	if len(intervals) != 1 {
+		return lexer.ILLEGAL
+	}
+
+	return intervals[0].Unit
+}
@@ -0,0 +1,434 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+	"github.com/itsubaki/gostream/stream"
+)
+
+// check returns an error if a field of type t cannot be used where it is referenced.
+type check func(t reflect.Type) error
+
+// ref is a field referenced in the query.
+// It is validated against the event type once FROM is parsed.
+type ref struct {
+	Name  string
+	Pos   lexer.Position
+	Check check
+}
+
+// field returns the field name at the cursor and records it to be validated with c.
+// c may be nil if the field only has to exist.
+func (p *Parser) field(c check) string {
+	if p.cursor.Token == lexer.ASTERISK {
+		return p.cursor.Literal
+	}
+
+	p.expect(lexer.IDENT)
+
+	pos := p.cursor.Pos
+	name := p.ident()
+	if p.tag != "" && !p.tags[stream.Path(name)[0]] {
+		// a field of the event of the step of a pattern
+		name = fmt.Sprintf("%v.%v", p.tag, name)
+	}
+
+	p.refs = append(p.refs, ref{Name: name, Pos: pos, Check: c})
+	return name
+}
+
+// validate reports the fields referenced in the query q that
+// the events of from do not have, or have with an incompatible type,
+// and the expressions of q whose operands have mismatched types.
+func (p *Parser) validate(from any, q *ast.Query) {
+	if from == nil {
+		return
+	}
+
+	for _, r := range p.refs {
+		t, ok := p.opt.Naming.Type(from, p.unqualify(r.Name))
+		if !ok {
+			p.errorf(r.Pos, "unknown field %v of %v", r.Name, stream.From{Type: from})
+			continue
+		}
+
+		if t == nil || r.Check == nil {
+			// known only when an event arrives
+			continue
+		}
+
+		if err := r.Check(t); err != nil {
+			p.errorf(r.Pos, "%v: %v", r.Name, err)
+		}
+	}
+
+	for _, f := range q.Fields {
+		if a, ok := f.(*ast.Alias); ok {
+			f = a.Expr
+		}
+
+		x, ok := f.(*ast.Call)
+		if !ok || p.aggregates[x.Name] == nil {
+			p.typeof(from, f)
+			continue
+		}
+
+		// the first argument of a user-defined aggregate function is an expression,
+		// and the others are the literals passed to the function
+		if len(x.Args) == 0 {
+			p.errorf(p.pos[x], "wrong number of arguments to %v: expected at least 1, found 0", strings.ToUpper(x.Name))
+			continue
+		}
+
+		p.typeof(from, x.Args[0])
+		for i, a := range x.Args[1:] {
+			switch a := a.(type) {
+			case *ast.BasicLit:
+				if valid(a) {
+					continue
+				}
+			case *ast.Param:
+				continue
+			}
+
+			p.errorf(p.pos[a], "argument %v of %v: expected value, found %v", i+2, strings.ToUpper(x.Name), a)
+		}
+	}
+
+	if q.Pattern != nil {
+		for _, s := range q.Pattern.Steps {
+			if s.Where == nil {
+				continue
+			}
+
+			tag := s.Tag
+			if tag == "" {
+				tag = s.From
+			}
+
+			p.constrain(s.Where, "condition of "+tag, convertible(reflect.TypeOf(true)))
+			if err := convertible(reflect.TypeOf(true))(p.typeof(from, s.Where)); err != nil {
+				p.errorf(p.pos[s.Where], "%v: %v", s.Where, err)
+			}
+		}
+	}
+
+	if q.MatchRecognize != nil {
+		p.recognize(from, q)
+	}
+
+	if q.Where != nil {
+		p.typeof(from, q.Where)
+	}
+}
+
+// recognize reports the errors of the MATCH_RECOGNIZE clause of q, whose matches are selected with *.
+func (p *Parser) recognize(from any, q *ast.Query) {
+	for _, f := range q.Fields {
+		if _, ok := f.(*ast.Star); !ok {
+			p.errorf(p.pos[f], "select list with MATCH_RECOGNIZE must be *, found %v", f)
+			break
+		}
+	}
+
+	m := q.MatchRecognize
+	for _, x := range m.Measures {
+		ast.Inspect(x.Expr, func(x ast.Expr) bool {
+			if id, ok := x.(*ast.Ident); ok && !p.vars[stream.Path(id.Name)[0]] {
+				p.errorf(p.pos[id], "%v: field in MEASURES is not qualified by a pattern variable", id)
+			}
+
+			return true
+		})
+
+		p.typeof(from, x.Expr)
+	}
+
+	for _, d := range m.Define {
+		p.constrain(d.Cond, "condition of "+d.Name, convertible(reflect.TypeOf(true)))
+		if err := convertible(reflect.TypeOf(true))(p.typeof(from, d.Cond)); err != nil {
+			p.errorf(p.pos[d.Cond], "%v: %v", d.Cond, err)
+		}
+	}
+}
+
+// unqualify returns the field name without the variable of MATCH_RECOGNIZE that qualifies it,
+// such as Price of B.Price.
+func (p *Parser) unqualify(name string) string {
+	s := stream.Path(name)
+	if len(s) < 2 || !p.vars[s[0]] {
+		return name
+	}
+
+	return strings.Join(s[1:], ".")
+}
+
+// typeof returns the type of x for events of from, reporting the mismatched types in it.
+// The type is nil if it is known only when an event arrives, e.g. of a placeholder or NULL.
+// The types the placeholders in x are expected to have where they are used are recorded for bind.
+func (p *Parser) typeof(from any, x ast.Expr) reflect.Type {
+	switch x := x.(type) {
+	case *ast.Ident:
+		t, _ := p.opt.Naming.Type(from, p.unqualify(x.Name))
+		return t
+	case *ast.BasicLit:
+		v, err := value(x)
+		if err != nil || !valid(x) {
+			return nil
+		}
+
+		return reflect.TypeOf(v)
+	case *ast.Call:
+		if x.Name == "" {
+			switch x.Func {
+			case lexer.PREV, lexer.FIRST, lexer.LAST:
+				if len(x.Args) == 1 {
+					// navigation in MATCH_RECOGNIZE
+					return p.typeof(from, x.Args[0])
+				}
+			}
+
+			// aggregate functions are checked with the fields they reference
+			return nil
+		}
+
+		if p.aggregates[x.Name] != nil {
+			p.errorf(p.pos[x], "aggregate function %v in expression", strings.ToUpper(x.Name))
+			return nil
+		}
+
+		fn, ok := p.function(x.Name)
+		if !ok {
+			return nil
+		}
+
+		t := reflect.TypeOf(fn)
+		n, want := len(x.Args), fmt.Sprintf("%v", t.NumIn())
+		if t.IsVariadic() {
+			want = fmt.Sprintf("at least %v", t.NumIn()-1)
+		}
+
+		if n != t.NumIn() && !(t.IsVariadic() && n >= t.NumIn()-1) {
+			p.errorf(p.pos[x], "wrong number of arguments to %v: expected %v, found %v", strings.ToUpper(x.Name), want, n)
+			return t.Out(0)
+		}
+
+		_, user := p.funcs[x.Name]
+		for i, a := range x.Args {
+			param := convertible(stream.ArgType(t, i))
+			p.constrain(a, fmt.Sprintf("argument %v of %v", i+1, strings.ToUpper(x.Name)), param)
+			if err := param(p.typeof(from, a)); err != nil {
+				p.errorf(p.pos[a], "argument %v of %v: %v", i+1, strings.ToUpper(x.Name), err)
+				continue
+			}
+
+			if lit, ok := a.(*ast.BasicLit); ok && valid(lit) && !user {
+				v, err := value(lit)
+				if err != nil {
+					continue
+				}
+
+				if err := stream.ValidateArg(x.Name, i, v); err != nil {
+					p.errorf(p.pos[a], "argument %v of %v: %v", i+1, strings.ToUpper(x.Name), err)
+				}
+			}
+		}
+
+		return t.Out(0)
+	case *ast.BinaryExpr:
+		tx, ty := p.typeof(from, x.X), p.typeof(from, x.Y)
+		if x.Op == lexer.AND {
+			return reflect.TypeOf(true)
+		}
+
+		p.compare(x.Y, x.X, tx)
+		p.compare(x.X, x.Y, ty)
+
+		// a literal is checked as the value it is, e.g. NULL
+		if lit, ok := x.Y.(*ast.BasicLit); ok && tx != nil && valid(lit) {
+			if v, err := value(lit); err == nil {
+				ty = nil
+				if err := literal(v)(tx); err != nil {
+					p.errorf(p.pos[x.X], "%v: %v", x.X, err)
+				}
+			}
+		}
+
+		if tx != nil && ty != nil {
+			if err := compatible(tx, ty); err != nil {
+				p.errorf(p.pos[x.X], "%v: %v", x.X, err)
+			}
+		}
+
+		return reflect.TypeOf(true)
+	case *ast.Case:
+		var tv reflect.Type
+		if x.Value != nil {
+			tv = p.typeof(from, x.Value)
+		}
+
+		var t reflect.Type
+		for _, w := range x.When {
+			tc := p.typeof(from, w.Cond)
+			if x.Value == nil {
+				p.constrain(w.Cond, "condition of CASE", convertible(reflect.TypeOf(true)))
+				if err := convertible(reflect.TypeOf(true))(tc); err != nil {
+					p.errorf(p.pos[w.Cond], "%v: %v", w.Cond, err)
+				}
+			} else {
+				p.compare(w.Cond, x.Value, tv)
+				if tv != nil && tc != nil {
+					if err := compatible(tv, tc); err != nil {
+						p.errorf(p.pos[w.Cond], "%v: %v", w.Cond, err)
+					}
+				}
+			}
+
+			t = p.result(from, t, w.Then)
+		}
+
+		if x.Else != nil {
+			t = p.result(from, t, x.Else)
+		}
+
+		if t != nil {
+			for _, w := range x.When {
+				p.constrain(w.Then, "result of CASE", convertible(t))
+			}
+
+			p.constrain(x.Else, "result of CASE", convertible(t))
+		}
+
+		return t
+	case *ast.Alias:
+		return p.typeof(from, x.Expr)
+	}
+
+	return nil
+}
+
+// result returns the type of the results of a CASE expression, t so far, with the result x.
+// It reports x if its type is not compatible with t.
+func (p *Parser) result(from any, t reflect.Type, x ast.Expr) reflect.Type {
+	tx := p.typeof(from, x)
+	if t == nil {
+		return tx
+	}
+
+	if tx != nil {
+		if err := compatible(t, tx); err != nil {
+			p.errorf(p.pos[x], "%v: %v", x, err)
+		}
+	}
+
+	return t
+}
+
+// valid reports whether lit is a literal, and not a token found instead of one.
+func valid(lit *ast.BasicLit) bool {
+	return lexer.IsBasicLit(lit.Kind) || lit.Kind == lexer.TIMESTAMP
+}
+
+// convertible returns a check that a value of type t can be passed as an argument of type param,
+// as stream.Convertible reports. A value of unknown type t, nil, is checked when an event arrives.
+func convertible(param reflect.Type) check {
+	return func(t reflect.Type) error {
+		if t == nil || stream.Convertible(t, param) {
+			return nil
+		}
+
+		return fmt.Errorf("cannot use %v as %v", t, param)
+	}
+}
+
+// compatible returns an error if values of types a and b cannot be compared.
+func compatible(a, b reflect.Type) error {
+	if convertible(a)(b) == nil {
+		return nil
+	}
+
+	return fmt.Errorf("mismatched types %v and %v", a, b)
+}
+
+func numeric(t reflect.Type) error {
+	switch indirect(t).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface:
+		return nil
+	}
+
+	return fmt.Errorf("%v is not numeric", t)
+}
+
+func ordered(t reflect.Type) error {
+	if indirect(t) == reflect.TypeOf(time.Time{}) {
+		return nil
+	}
+
+	switch indirect(t).Kind() {
+	case reflect.String, reflect.Bool:
+		return nil
+	}
+
+	if numeric(t) == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%v is not ordered", t)
+}
+
+// literal returns a check that a field can be compared with the value v.
+func literal(v any) check {
+	return func(t reflect.Type) error {
+		k := indirect(t).Kind()
+		if k == reflect.Interface {
+			return nil
+		}
+
+		var ok bool
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			ok = numeric(t) == nil
+		case reflect.String:
+			ok = k == reflect.String
+		case reflect.Bool:
+			ok = k == reflect.Bool
+		case reflect.Struct:
+			ok = indirect(t) == reflect.TypeOf(v)
+		case reflect.Invalid:
+			// NULL
+			switch t.Kind() {
+			case reflect.Pointer, reflect.Map, reflect.Slice:
+				ok = true
+			}
+		default:
+			ok = true
+		}
+
+		if !ok {
+			if v == nil {
+				return fmt.Errorf("%v is not nullable", t)
+			}
+
+			return fmt.Errorf("mismatched types %v and %T", t, v)
+		}
+
+		return nil
+	}
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
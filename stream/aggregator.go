@@ -2,7 +2,11 @@ package stream
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
+
+	"github.com/itsubaki/gostream/ast"
 )
 
 var (
@@ -12,6 +16,61 @@ var (
 	_ Aggeregator = (*Max)(nil)
 	_ Aggeregator = (*Min)(nil)
 	_ Aggeregator = (*Distinct)(nil)
+	_ Aggeregator = (*Variance)(nil)
+	_ Aggeregator = (*StdDev)(nil)
+	_ Aggeregator = (*Median)(nil)
+	_ Aggeregator = (*Percentile)(nil)
+	_ Aggeregator = (*ApproxPercentile)(nil)
+	_ Aggeregator = (*ApproxCountDistinct)(nil)
+	_ Aggeregator = (*TopK)(nil)
+	_ Aggeregator = (*First)(nil)
+	_ Aggeregator = (*Last)(nil)
+	_ Aggeregator = (*MaxBy)(nil)
+	_ Aggeregator = (*MinBy)(nil)
+	_ Aggeregator = (*ArrayAgg)(nil)
+	_ Aggeregator = (*UserDefined)(nil)
+)
+
+var (
+	_ Accumulator = (*Average)(nil)
+	_ Accumulator = (*Sum)(nil)
+	_ Accumulator = (*Count)(nil)
+	_ Accumulator = (*Max)(nil)
+	_ Accumulator = (*Min)(nil)
+	_ Accumulator = (*Variance)(nil)
+	_ Accumulator = (*StdDev)(nil)
+	_ Accumulator = (*Median)(nil)
+	_ Accumulator = (*Percentile)(nil)
+	_ Accumulator = (*ApproxPercentile)(nil)
+	_ Accumulator = (*ApproxCountDistinct)(nil)
+	_ Accumulator = (*TopK)(nil)
+	_ Accumulator = (*First)(nil)
+	_ Accumulator = (*Last)(nil)
+	_ Accumulator = (*MaxBy)(nil)
+	_ Accumulator = (*MinBy)(nil)
+	_ Accumulator = (*ArrayAgg)(nil)
+	_ Accumulator = (*UserDefined)(nil)
+)
+
+var (
+	_ binder = (*Average)(nil)
+	_ binder = (*Sum)(nil)
+	_ binder = (*Max)(nil)
+	_ binder = (*Min)(nil)
+	_ binder = (*Distinct)(nil)
+	_ binder = (*Variance)(nil)
+	_ binder = (*StdDev)(nil)
+	_ binder = (*Median)(nil)
+	_ binder = (*Percentile)(nil)
+	_ binder = (*ApproxPercentile)(nil)
+	_ binder = (*ApproxCountDistinct)(nil)
+	_ binder = (*TopK)(nil)
+	_ binder = (*First)(nil)
+	_ binder = (*Last)(nil)
+	_ binder = (*MaxBy)(nil)
+	_ binder = (*MinBy)(nil)
+	_ binder = (*ArrayAgg)(nil)
+	_ binder = (*UserDefined)(nil)
 )
 
 type Aggeregator interface {
@@ -19,202 +78,942 @@ type Aggeregator interface {
 	String() string
 }
 
-type Average struct {
-	Name string
+// Accumulator is an Aggeregator that keeps its state incrementally.
+// Stream calls Add for every event inserted into the window and Remove
+// for every event expired from it, so Result costs O(1) per event
+// instead of a rescan of the whole window.
+type Accumulator interface {
+	Aggeregator
+	Add(e Event)
+	Remove(e Event)
+	Result() any
 }
 
-func (s Average) Apply(e []Event) []Event {
-	var sum float64
+// accumulate applies a to e from scratch and appends the result to the last event.
+func accumulate(a Accumulator, e []Event) []Event {
 	for _, ev := range e {
-		v := reflect.ValueOf(ev.Underlying)
-		for i := 0; i < v.Type().NumField(); i++ {
-			if v.Type().Field(i).Name != s.Name {
-				continue
-			}
-
-			val := v.Field(i).Interface()
-			switch val := val.(type) {
-			case int:
-				sum += float64(val)
-			case int32:
-				sum += float64(val)
-			case int64:
-				sum += float64(val)
-			case float32:
-				sum += float64(val)
-			case float64:
-				sum += val
-			}
-		}
+		a.Add(ev)
 	}
 
-	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, sum/float64(len(e)))
+	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, a.Result())
 	return e
 }
 
-func (s Average) String() string {
+func number(input any, name string, index []int) (float64, bool) {
+	v, ok := field(input, name, index)
+	if !ok {
+		return 0, false
+	}
+
+	return float(v)
+}
+
+type Average struct {
+	Name  string
+	Index []int
+	sum   compensated
+	count int
+}
+
+func (s *Average) Apply(e []Event) []Event {
+	return accumulate(&Average{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *Average) Add(e Event) {
+	v, _ := number(e.Underlying, s.Name, s.Index)
+	s.sum.add(v)
+	s.count++
+}
+
+func (s *Average) Remove(e Event) {
+	v, _ := number(e.Underlying, s.Name, s.Index)
+	s.sum.add(-v)
+	s.count--
+}
+
+func (s *Average) Result() any {
+	if s.count == 0 {
+		return float64(0)
+	}
+
+	return s.sum.value() / float64(s.count)
+}
+
+func (s *Average) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Average) String() string {
 	return fmt.Sprintf("AVG(%v)", s.Name)
 }
 
 type Sum struct {
-	Name string
+	Name  string
+	Index []int
+	sum   compensated
 }
 
-func (s Sum) Apply(e []Event) []Event {
-	var sum float64
-	for _, ev := range e {
-		v := reflect.ValueOf(ev.Underlying)
-		for i := 0; i < v.Type().NumField(); i++ {
-			if v.Type().Field(i).Name != s.Name {
-				continue
-			}
-
-			val := v.Field(i).Interface()
-			switch val := val.(type) {
-			case int:
-				sum += float64(val)
-			case int32:
-				sum += float64(val)
-			case int64:
-				sum += float64(val)
-			case float32:
-				sum += float64(val)
-			case float64:
-				sum += val
-			}
-		}
-	}
+func (s *Sum) Apply(e []Event) []Event {
+	return accumulate(&Sum{Name: s.Name, Index: s.Index}, e)
+}
 
-	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, sum)
-	return e
+func (s *Sum) Add(e Event) {
+	v, _ := number(e.Underlying, s.Name, s.Index)
+	s.sum.add(v)
+}
+
+func (s *Sum) Remove(e Event) {
+	v, _ := number(e.Underlying, s.Name, s.Index)
+	s.sum.add(-v)
 }
 
-func (s Sum) String() string {
+func (s *Sum) Result() any {
+	return s.sum.value()
+}
+
+func (s *Sum) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Sum) String() string {
 	return fmt.Sprintf("SUM(%v)", s.Name)
 }
 
 type Count struct {
-	Name string
+	Name  string
+	count int
 }
 
-func (s Count) Apply(e []Event) []Event {
-	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, len(e))
-	return e
+func (s *Count) Apply(e []Event) []Event {
+	return accumulate(&Count{Name: s.Name}, e)
+}
+
+func (s *Count) Add(e Event) {
+	s.count++
+}
+
+func (s *Count) Remove(e Event) {
+	s.count--
 }
 
-func (s Count) String() string {
+func (s *Count) Result() any {
+	return s.count
+}
+
+func (s *Count) String() string {
 	return fmt.Sprintf("COUNT(%v)", s.Name)
 }
 
+// Max is the largest value in the window, of the type of the field.
+// Any ordered type is supported: integers, floats, strings, time.Time and time.Duration.
 type Max struct {
-	Name string
+	Name   string
+	Index  []int
+	values monotonic
 }
 
-func (s Max) Apply(e []Event) []Event {
-	var max float64
-	for _, ev := range e {
-		v := reflect.ValueOf(ev.Underlying)
-		for i := 0; i < v.Type().NumField(); i++ {
-			if v.Type().Field(i).Name != s.Name {
-				continue
-			}
-
-			val := v.Field(i).Interface()
-			switch val := val.(type) {
-			case int:
-				if float64(val) > max {
-					max = float64(val)
-				}
-			case int32:
-				if float64(val) > max {
-					max = float64(val)
-				}
-			case int64:
-				if float64(val) > max {
-					max = float64(val)
-				}
-			case float32:
-				if float64(val) > max {
-					max = float64(val)
-				}
-			case float64:
-				if val > max {
-					max = val
-				}
-			}
-		}
+func (s *Max) Apply(e []Event) []Event {
+	return accumulate(&Max{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *Max) Add(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok && ordered(v) {
+		s.values.push(sample{seq: e.seq, key: v}, lesseq)
 	}
+}
 
-	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, max)
-	return e
+func (s *Max) Remove(e Event) {
+	s.values.pop(e.seq)
+}
+
+func (s *Max) Result() any {
+	return s.values.front().key
 }
 
-func (s Max) String() string {
+func (s *Max) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Max) String() string {
 	return fmt.Sprintf("MAX(%v)", s.Name)
 }
 
+// Min is the smallest value in the window, of the type of the field.
+// Any ordered type is supported: integers, floats, strings, time.Time and time.Duration.
 type Min struct {
-	Name string
+	Name   string
+	Index  []int
+	values monotonic
 }
 
-func (s Min) Apply(e []Event) []Event {
-	var min float64
-	for _, ev := range e {
-		v := reflect.ValueOf(ev.Underlying)
-		for i := 0; i < v.Type().NumField(); i++ {
-			if v.Type().Field(i).Name != s.Name {
-				continue
-			}
-
-			val := v.Field(i).Interface()
-			switch val := val.(type) {
-			case int:
-				if float64(val) < min {
-					min = float64(val)
-				}
-			case int32:
-				if float64(val) < min {
-					min = float64(val)
-				}
-			case int64:
-				if float64(val) < min {
-					min = float64(val)
-				}
-			case float32:
-				if float64(val) < min {
-					min = float64(val)
-				}
-			case float64:
-				if val < min {
-					min = val
-				}
-			}
-		}
+func (s *Min) Apply(e []Event) []Event {
+	return accumulate(&Min{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *Min) Add(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok && ordered(v) {
+		s.values.push(sample{seq: e.seq, key: v}, greatereq)
 	}
+}
 
-	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, min)
-	return e
+func (s *Min) Remove(e Event) {
+	s.values.pop(e.seq)
 }
 
-func (s Min) String() string {
+func (s *Min) Result() any {
+	return s.values.front().key
+}
+
+func (s *Min) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Min) String() string {
 	return fmt.Sprintf("MIN(%v)", s.Name)
 }
 
+// First is the value of the oldest event in the window.
+type First struct {
+	Name   string
+	Index  []int
+	values fifo
+}
+
+func (s *First) Apply(e []Event) []Event {
+	return accumulate(&First{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *First) Add(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok {
+		s.values.push(sample{seq: e.seq, value: v})
+	}
+}
+
+func (s *First) Remove(e Event) {
+	s.values.pop(e.seq)
+}
+
+func (s *First) Result() any {
+	if len(s.values) == 0 {
+		return nil
+	}
+
+	return s.values[0].value
+}
+
+func (s *First) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *First) String() string {
+	return fmt.Sprintf("FIRST(%v)", s.Name)
+}
+
+// Last is the value of the newest event in the window.
+type Last struct {
+	Name  string
+	Index []int
+	value any
+	count int
+}
+
+func (s *Last) Apply(e []Event) []Event {
+	return accumulate(&Last{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *Last) Add(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok {
+		s.value = v
+		s.count++
+	}
+}
+
+func (s *Last) Remove(e Event) {
+	if _, ok := field(e.Underlying, s.Name, s.Index); !ok {
+		return
+	}
+
+	// events expire in arrival order, so the newest one expires last
+	s.count--
+	if s.count == 0 {
+		s.value = nil
+	}
+}
+
+func (s *Last) Result() any {
+	return s.value
+}
+
+func (s *Last) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Last) String() string {
+	return fmt.Sprintf("LAST(%v)", s.Name)
+}
+
+// MaxBy is the value of Name of the event with the largest value of By.
+// If several events have the largest value, the newest one is used.
+type MaxBy struct {
+	Name    string
+	Index   []int
+	By      string
+	ByIndex []int
+	values  monotonic
+}
+
+func (s *MaxBy) Apply(e []Event) []Event {
+	return accumulate(&MaxBy{Name: s.Name, Index: s.Index, By: s.By, ByIndex: s.ByIndex}, e)
+}
+
+func (s *MaxBy) Add(e Event) {
+	k, ok := field(e.Underlying, s.By, s.ByIndex)
+	if !ok || !ordered(k) {
+		return
+	}
+
+	v, ok := field(e.Underlying, s.Name, s.Index)
+	if !ok {
+		return
+	}
+
+	s.values.push(sample{seq: e.seq, key: k, value: v}, lesseq)
+}
+
+func (s *MaxBy) Remove(e Event) {
+	s.values.pop(e.seq)
+}
+
+func (s *MaxBy) Result() any {
+	return s.values.front().value
+}
+
+func (s *MaxBy) bind(r resolver) {
+	s.Index = r(s.Name)
+	s.ByIndex = r(s.By)
+}
+
+func (s *MaxBy) String() string {
+	return fmt.Sprintf("MAX_BY(%v, %v)", s.Name, s.By)
+}
+
+// MinBy is the value of Name of the event with the smallest value of By.
+// If several events have the smallest value, the newest one is used.
+type MinBy struct {
+	Name    string
+	Index   []int
+	By      string
+	ByIndex []int
+	values  monotonic
+}
+
+func (s *MinBy) Apply(e []Event) []Event {
+	return accumulate(&MinBy{Name: s.Name, Index: s.Index, By: s.By, ByIndex: s.ByIndex}, e)
+}
+
+func (s *MinBy) Add(e Event) {
+	k, ok := field(e.Underlying, s.By, s.ByIndex)
+	if !ok || !ordered(k) {
+		return
+	}
+
+	v, ok := field(e.Underlying, s.Name, s.Index)
+	if !ok {
+		return
+	}
+
+	s.values.push(sample{seq: e.seq, key: k, value: v}, greatereq)
+}
+
+func (s *MinBy) Remove(e Event) {
+	s.values.pop(e.seq)
+}
+
+func (s *MinBy) Result() any {
+	return s.values.front().value
+}
+
+func (s *MinBy) bind(r resolver) {
+	s.Index = r(s.Name)
+	s.ByIndex = r(s.By)
+}
+
+func (s *MinBy) String() string {
+	return fmt.Sprintf("MIN_BY(%v, %v)", s.Name, s.By)
+}
+
+// ArrayAgg is the values of all events in the window in arrival order.
+// The result is a slice of the type of the field, e.g. []string,
+// or []any if the values have different types.
+type ArrayAgg struct {
+	Name   string
+	Index  []int
+	values fifo
+}
+
+func (s *ArrayAgg) Apply(e []Event) []Event {
+	return accumulate(&ArrayAgg{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *ArrayAgg) Add(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok {
+		s.values.push(sample{seq: e.seq, value: v})
+	}
+}
+
+func (s *ArrayAgg) Remove(e Event) {
+	s.values.pop(e.seq)
+}
+
+func (s *ArrayAgg) Result() any {
+	if len(s.values) == 0 {
+		return nil
+	}
+
+	t := reflect.TypeOf(s.values[0].value)
+	for _, v := range s.values {
+		if reflect.TypeOf(v.value) == t && t != nil {
+			continue
+		}
+
+		out := make([]any, 0, len(s.values))
+		for _, v := range s.values {
+			out = append(out, v.value)
+		}
+
+		return out
+	}
+
+	out := reflect.MakeSlice(reflect.SliceOf(t), 0, len(s.values))
+	for _, v := range s.values {
+		out = reflect.Append(out, reflect.ValueOf(v.value))
+	}
+
+	return out.Interface()
+}
+
+func (s *ArrayAgg) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *ArrayAgg) String() string {
+	return fmt.Sprintf("ARRAY_AGG(%v)", s.Name)
+}
+
+// sample is a value of the event seq in the window.
+// key is the value used for ordering, value is returned as is.
+type sample struct {
+	seq   uint64
+	key   any
+	value any
+}
+
+// monotonic is a sliding window max/min.
+// Every value dominated by a newer one can never become the extreme
+// of the window again, so it is dropped on push and the front is always the result.
+// Events expire in arrival order, so pop only has to look at the front.
+type monotonic []sample
+
+func (m *monotonic) push(v sample, dominated func(a, b any) bool) {
+	for len(*m) > 0 && dominated((*m)[len(*m)-1].key, v.key) {
+		*m = (*m)[:len(*m)-1]
+	}
+
+	*m = append(*m, v)
+}
+
+func (m *monotonic) pop(seq uint64) {
+	if len(*m) > 0 && (*m)[0].seq == seq {
+		*m = (*m)[1:]
+	}
+}
+
+// front returns the extreme of the window, or the zero sample if it is empty.
+func (m monotonic) front() sample {
+	if len(m) == 0 {
+		return sample{}
+	}
+
+	return m[0]
+}
+
+// fifo is the values of the events in the window in arrival order.
+type fifo []sample
+
+func (q *fifo) push(v sample) {
+	*q = append(*q, v)
+}
+
+func (q *fifo) pop(seq uint64) {
+	if len(*q) > 0 && (*q)[0].seq == seq {
+		*q = (*q)[1:]
+	}
+}
+
+// Variance is the sample variance.
+// It is computed with Welford's algorithm, which is numerically stable
+// and can also remove a value when it expires from the window.
+type Variance struct {
+	Name    string
+	Index   []int
+	moments moments
+}
+
+func (s *Variance) Apply(e []Event) []Event {
+	return accumulate(&Variance{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *Variance) Add(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.moments.add(v)
+	}
+}
+
+func (s *Variance) Remove(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.moments.remove(v)
+	}
+}
+
+func (s *Variance) Result() any {
+	return s.moments.variance()
+}
+
+func (s *Variance) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Variance) String() string {
+	return fmt.Sprintf("VARIANCE(%v)", s.Name)
+}
+
+// StdDev is the sample standard deviation.
+type StdDev struct {
+	Name    string
+	Index   []int
+	moments moments
+}
+
+func (s *StdDev) Apply(e []Event) []Event {
+	return accumulate(&StdDev{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *StdDev) Add(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.moments.add(v)
+	}
+}
+
+func (s *StdDev) Remove(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.moments.remove(v)
+	}
+}
+
+func (s *StdDev) Result() any {
+	return math.Sqrt(s.moments.variance())
+}
+
+func (s *StdDev) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *StdDev) String() string {
+	return fmt.Sprintf("STDDEV(%v)", s.Name)
+}
+
+// compensated is a running sum with Neumaier's compensation of the rounding errors,
+// so that it does not drift as values are added to and removed from the window for a long time.
+type compensated struct {
+	sum float64
+	c   float64
+}
+
+func (s *compensated) add(v float64) {
+	t := s.sum + v
+	if math.Abs(s.sum) >= math.Abs(v) {
+		s.c += (s.sum - t) + v
+	} else {
+		s.c += (v - t) + s.sum
+	}
+
+	s.sum = t
+}
+
+func (s *compensated) value() float64 {
+	return s.sum + s.c
+}
+
+// moments is the running mean and sum of squared deviations of Welford's algorithm.
+type moments struct {
+	count int
+	mean  float64
+	m2    float64
+}
+
+func (m *moments) add(v float64) {
+	m.count++
+	d := v - m.mean
+	m.mean += d / float64(m.count)
+	m.m2 += d * (v - m.mean)
+}
+
+func (m *moments) remove(v float64) {
+	m.count--
+	if m.count == 0 {
+		m.mean, m.m2 = 0, 0
+		return
+	}
+
+	d := v - m.mean
+	m.mean -= d / float64(m.count)
+	m.m2 -= d * (v - m.mean)
+}
+
+func (m *moments) variance() float64 {
+	if m.count < 2 {
+		return 0
+	}
+
+	return math.Max(m.m2, 0) / float64(m.count-1)
+}
+
+type Median struct {
+	Name   string
+	Index  []int
+	values sorted
+}
+
+func (s *Median) Apply(e []Event) []Event {
+	return accumulate(&Median{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *Median) Add(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.values.insert(v)
+	}
+}
+
+func (s *Median) Remove(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.values.remove(v)
+	}
+}
+
+func (s *Median) Result() any {
+	return s.values.quantile(0.5)
+}
+
+func (s *Median) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Median) String() string {
+	return fmt.Sprintf("MEDIAN(%v)", s.Name)
+}
+
+// Percentile is the exact percentile, interpolated linearly between the closest ranks.
+// Percent is in the range [0, 100].
+type Percentile struct {
+	Name    string
+	Index   []int
+	Percent float64
+	values  sorted
+}
+
+func (s *Percentile) Apply(e []Event) []Event {
+	return accumulate(&Percentile{Name: s.Name, Index: s.Index, Percent: s.Percent}, e)
+}
+
+func (s *Percentile) Add(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.values.insert(v)
+	}
+}
+
+func (s *Percentile) Remove(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.values.remove(v)
+	}
+}
+
+func (s *Percentile) Result() any {
+	return s.values.quantile(s.Percent / 100)
+}
+
+func (s *Percentile) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Percentile) String() string {
+	return fmt.Sprintf("PERCENTILE(%v, %v)", s.Name, s.Percent)
+}
+
+// DefaultAccuracy is the relative accuracy of ApproxPercentile
+// when its Accuracy is not larger than 0 and less than 1, e.g. the zero value.
+const DefaultAccuracy = 0.01
+
+// ApproxPercentile is the percentile estimated by a sketch
+// with the relative Accuracy (e.g. 0.01 for 1%).
+// It uses bounded memory regardless of the number of events in the window.
+type ApproxPercentile struct {
+	Name     string
+	Index    []int
+	Percent  float64
+	Accuracy float64
+	sketch   *ddsketch
+}
+
+func (s *ApproxPercentile) Apply(e []Event) []Event {
+	return accumulate(&ApproxPercentile{Name: s.Name, Index: s.Index, Percent: s.Percent, Accuracy: s.Accuracy}, e)
+}
+
+func (s *ApproxPercentile) Add(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.init().add(v, 1)
+	}
+}
+
+func (s *ApproxPercentile) Remove(e Event) {
+	if v, ok := number(e.Underlying, s.Name, s.Index); ok {
+		s.init().add(v, -1)
+	}
+}
+
+func (s *ApproxPercentile) Result() any {
+	return s.init().quantile(s.Percent / 100)
+}
+
+func (s *ApproxPercentile) init() *ddsketch {
+	if s.sketch == nil {
+		alpha := s.Accuracy
+		if alpha <= 0 || alpha >= 1 {
+			alpha = DefaultAccuracy
+		}
+
+		s.sketch = newDDSketch(alpha)
+	}
+
+	return s.sketch
+}
+
+func (s *ApproxPercentile) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *ApproxPercentile) String() string {
+	return fmt.Sprintf("APPROX_PERCENTILE(%v, %v, %v)", s.Name, s.Percent, s.Accuracy)
+}
+
+// ApproxCountDistinct is the number of distinct values estimated by HyperLogLog.
+// The standard error is about 0.81% and the memory is bounded by the number of registers,
+// regardless of the number of events in the window.
+type ApproxCountDistinct struct {
+	Name   string
+	Index  []int
+	sketch *hyperloglog
+}
+
+func (s *ApproxCountDistinct) Apply(e []Event) []Event {
+	return accumulate(&ApproxCountDistinct{Name: s.Name, Index: s.Index}, e)
+}
+
+func (s *ApproxCountDistinct) Add(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok {
+		s.init().add(e.seq, v)
+	}
+}
+
+func (s *ApproxCountDistinct) Remove(e Event) {
+	if v, ok := field(e.Underlying, s.Name, s.Index); ok {
+		s.init().remove(e.seq, v)
+	}
+}
+
+func (s *ApproxCountDistinct) Result() any {
+	return s.init().count()
+}
+
+func (s *ApproxCountDistinct) init() *hyperloglog {
+	if s.sketch == nil {
+		s.sketch = newHyperLogLog()
+	}
+
+	return s.sketch
+}
+
+func (s *ApproxCountDistinct) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *ApproxCountDistinct) String() string {
+	return fmt.Sprintf("APPROX_COUNT_DISTINCT(%v)", s.Name)
+}
+
+// Frequency is the estimated number of events with Value.
+type Frequency struct {
+	Value any `json:"value"`
+	Count int `json:"count"`
+}
+
+// TopK is the K most frequent values, the heavy hitters of the window.
+// Numbers of the same value such as 1 and 1.0 are the same value, given as the first of them that arrived.
+// Counts are estimated by a Count-Min Sketch. They are never underestimated,
+// and overestimated by at most about 0.13% of the events in the window with probability about 99.3%.
+// Besides the sketch, 4K candidates are tracked as in the Space-Saving algorithm,
+// so the memory is bounded. A value that arrives replaces the least frequent candidate if it is more frequent,
+// and the candidates after the K most frequent ones take their places when they expire from the window.
+type TopK struct {
+	Name   string
+	Index  []int
+	K      int
+	sketch *countmin
+	top    map[any]any
+}
+
+func (s *TopK) Apply(e []Event) []Event {
+	return accumulate(&TopK{Name: s.Name, Index: s.Index, K: s.K}, e)
+}
+
+func (s *TopK) Add(e Event) {
+	v, ok := field(e.Underlying, s.Name, s.Index)
+	if !ok {
+		return
+	}
+
+	s.init().add(v, 1)
+	k := key(v)
+	if _, ok := s.top[k]; ok {
+		return
+	}
+
+	if len(s.top) < s.K*topkCandidates {
+		s.top[k] = v
+		return
+	}
+
+	// replace the least frequent candidate
+	var least any
+	count := math.MaxInt
+	for c, cv := range s.top {
+		if n := s.sketch.estimate(cv); n < count {
+			least, count = c, n
+		}
+	}
+
+	if s.sketch.estimate(v) > count {
+		delete(s.top, least)
+		s.top[k] = v
+	}
+}
+
+func (s *TopK) Remove(e Event) {
+	v, ok := field(e.Underlying, s.Name, s.Index)
+	if !ok {
+		return
+	}
+
+	s.init().add(v, -1)
+	if s.sketch.estimate(v) == 0 {
+		delete(s.top, key(v))
+	}
+}
+
+func (s *TopK) Result() any {
+	out := make([]Frequency, 0, len(s.top))
+	for _, v := range s.top {
+		out = append(out, Frequency{Value: v, Count: s.init().estimate(v)})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return fmt.Sprint(out[i].Value) < fmt.Sprint(out[j].Value)
+		}
+
+		return out[i].Count > out[j].Count
+	})
+
+	if len(out) > s.K {
+		out = out[:max(s.K, 0)]
+	}
+
+	return out
+}
+
+func (s *TopK) init() *countmin {
+	if s.sketch == nil {
+		s.sketch = newCountMin()
+		s.top = make(map[any]any)
+	}
+
+	return s.sketch
+}
+
+func (s *TopK) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *TopK) String() string {
+	return fmt.Sprintf("TOPK(%v, %v)", s.Name, s.K)
+}
+
+// sorted is a sorted multiset of values.
+type sorted []float64
+
+func (s *sorted) insert(v float64) {
+	i := sort.SearchFloat64s(*s, v)
+	*s = append(*s, 0)
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = v
+}
+
+func (s *sorted) remove(v float64) {
+	i := sort.SearchFloat64s(*s, v)
+	if i < len(*s) && (*s)[i] == v {
+		*s = append((*s)[:i], (*s)[i+1:]...)
+	}
+}
+
+func (s sorted) quantile(q float64) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+
+	r := clamp(q) * float64(len(s)-1)
+	i := int(r)
+	if i+1 >= len(s) {
+		return s[len(s)-1]
+	}
+
+	return s[i] + (r-float64(i))*(s[i+1]-s[i])
+}
+
+// clamp returns the quantile q in the range [0, 1], or 0 for NaN.
+func clamp(q float64) float64 {
+	switch {
+	case !(q > 0):
+		return 0
+	case q > 1:
+		return 1
+	}
+
+	return q
+}
+
 type Distinct struct {
-	Name string
+	Name  string
+	Index []int
 }
 
-func (s Distinct) Apply(e []Event) []Event {
+func (s *Distinct) Apply(e []Event) []Event {
 	dist := make(map[interface{}]int)
 	for i, ev := range e {
-		v := reflect.ValueOf(ev.Underlying)
-		for j := 0; j < v.Type().NumField(); j++ {
-			if v.Type().Field(j).Name != s.Name {
-				continue
-			}
-
-			dist[v.Field(j).Interface()] = i
+		v, ok := field(ev.Underlying, s.Name, s.Index)
+		if !ok {
+			continue
 		}
+
+		dist[v] = i
 	}
 
 	out := make([]Event, 0)
@@ -225,6 +1024,76 @@ func (s Distinct) Apply(e []Event) []Event {
 	return out
 }
 
-func (s Distinct) String() string {
+func (s *Distinct) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
+func (s *Distinct) String() string {
 	return fmt.Sprintf("DISTINCT(%v)", s.Name)
 }
+
+// Aggregate is the state of a user-defined aggregate function.
+// Add and Remove are called with the value of its argument for every event
+// inserted into and expired from the window, in arrival order.
+type Aggregate interface {
+	Add(v any)
+	Remove(v any)
+	Result() any
+}
+
+// AggregateFunc returns the initial state of a user-defined aggregate function.
+// args are the values of the literal arguments after the first one, e.g. 0.9 of P2QUANTILE(Latency, 0.9).
+type AggregateFunc func(args ...any) Aggregate
+
+// UserDefined is the user-defined aggregate function Func called by Name over the values of Expr.
+type UserDefined struct {
+	Name  string
+	Func  AggregateFunc
+	Expr  Expr
+	Args  []any
+	state Aggregate
+}
+
+func (s *UserDefined) Apply(e []Event) []Event {
+	return accumulate(&UserDefined{Name: s.Name, Func: s.Func, Expr: s.Expr, Args: s.Args}, e)
+}
+
+func (s *UserDefined) Add(e Event) {
+	s.init().Add(s.Expr.Eval(e.Underlying))
+}
+
+func (s *UserDefined) Remove(e Event) {
+	s.init().Remove(s.Expr.Eval(e.Underlying))
+}
+
+func (s *UserDefined) Result() any {
+	return s.init().Result()
+}
+
+func (s *UserDefined) init() Aggregate {
+	if s.state == nil {
+		s.state = s.Func(s.Args...)
+	}
+
+	return s.state
+}
+
+func (s *UserDefined) bind(r resolver) {
+	if b, ok := s.Expr.(binder); ok {
+		b.bind(r)
+	}
+}
+
+func (s *UserDefined) String() string {
+	return s.call().String()
+}
+
+// call returns the syntax tree of the call.
+func (s *UserDefined) call() *ast.Call {
+	args := []ast.Expr{node(s.Expr)}
+	for _, a := range s.Args {
+		args = append(args, literal(a))
+	}
+
+	return &ast.Call{Name: s.Name, Args: args}
+}
@@ -2,6 +2,10 @@ package stream_test
 
 import (
 	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
 
 	"github.com/itsubaki/gostream/stream"
 )
@@ -133,3 +137,652 @@ func ExampleDistinct() {
 	// Output:
 	// 3
 }
+
+func TestAccumulator(t *testing.T) {
+	type LogEvent struct {
+		Level int
+	}
+
+	s := stream.New().
+		From(LogEvent{}).
+		Length(3).
+		Average("Level").
+		Sum("Level").
+		Count("Level").
+		Max("Level").
+		Min("Level")
+	defer s.Close()
+
+	cases := []struct {
+		in   int
+		want []any
+	}{
+		{5, []any{5.0, 5.0, 1, 5, 5}},
+		{3, []any{4.0, 8.0, 2, 5, 3}},
+		{4, []any{4.0, 12.0, 3, 5, 3}},
+		{1, []any{8.0 / 3, 8.0, 3, 4, 1}},
+		{2, []any{7.0 / 3, 7.0, 3, 4, 1}},
+		{6, []any{3.0, 9.0, 3, 6, 1}},
+		{7, []any{5.0, 15.0, 3, 7, 2}},
+	}
+
+	for _, c := range cases {
+		s.Listen(LogEvent{Level: c.in})
+		out := <-s.Output()
+
+		got := out[len(out)-1].ResultSet
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("in=%v, want=%v, got=%v", c.in, c.want, got)
+		}
+	}
+}
+
+func TestAccumulatorDistinct(t *testing.T) {
+	type LogEvent struct {
+		Level   int
+		Message string
+	}
+
+	s := stream.New().
+		From(LogEvent{}).
+		Length(10).
+		Distinct("Message").
+		Count("Level")
+	defer s.Close()
+
+	for _, m := range []string{"foo", "bar", "foo", "foo", "baz"} {
+		s.Listen(LogEvent{Level: 1, Message: m})
+	}
+
+	var out []stream.Event
+	for len(s.Output()) > 0 {
+		out = <-s.Output()
+	}
+
+	// COUNT after DISTINCT counts the distinct events, not all the events in the window
+	got := make([]any, 0)
+	for _, e := range out {
+		got = append(got, e.ResultSet...)
+	}
+
+	if len(out) != 3 || !reflect.DeepEqual(got, []any{3}) {
+		t.Errorf("len=%v, got=%v", len(out), got)
+	}
+}
+
+func TestSumDrift(t *testing.T) {
+	type LogEvent struct {
+		Value float64
+	}
+
+	sum, avg := &stream.Sum{Name: "Value"}, &stream.Average{Name: "Value"}
+	values := []float64{1e8, 0.1, 3.3, 1e-3}
+
+	window := make([]stream.Event, 0)
+	for i := 0; i < 1000000; i++ {
+		e := stream.NewEvent(LogEvent{Value: values[i%4] * float64(i%7+1)})
+		sum.Add(e)
+		avg.Add(e)
+
+		if window = append(window, e); len(window) > 3 {
+			sum.Remove(window[0])
+			avg.Remove(window[0])
+			window = window[1:]
+		}
+	}
+
+	var want float64
+	for _, e := range window {
+		want += e.Underlying.(LogEvent).Value
+	}
+
+	if got := sum.Result().(float64); math.Abs(got-want) > 1e-12 {
+		t.Errorf("sum: got=%v, want=%v", got, want)
+	}
+
+	if got := avg.Result().(float64); math.Abs(got-want/3) > 1e-12 {
+		t.Errorf("avg: got=%v, want=%v", got, want/3)
+	}
+}
+
+func BenchmarkSum(b *testing.B) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	e := make([]stream.Event, 0)
+	for i := 0; i < 1000; i++ {
+		e = append(e, stream.NewEvent(LogEvent{Level: i}))
+	}
+
+	cases := []struct {
+		name string
+		in   stream.Aggeregator
+	}{
+		{"FieldByName", &stream.Sum{Name: "Level"}},
+		{"FieldByIndex", &stream.Sum{Name: "Level", Index: []int{1}}},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				e[len(e)-1].ResultSet = e[len(e)-1].ResultSet[:0]
+				c.in.Apply(e)
+			}
+		})
+	}
+}
+
+func ExampleVariance() {
+	type LogEvent struct {
+		Latency float64
+	}
+
+	e := make([]stream.Event, 0)
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		e = append(e, stream.NewEvent(LogEvent{
+			Latency: v,
+		}))
+	}
+
+	v := &stream.Variance{Name: "Latency"}
+	out := v.Apply(e)
+
+	fmt.Printf("%.4f\n", out[len(out)-1].ResultSet...)
+
+	// Output:
+	// 4.5714
+}
+
+func ExampleStdDev() {
+	type LogEvent struct {
+		Latency float64
+	}
+
+	e := make([]stream.Event, 0)
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		e = append(e, stream.NewEvent(LogEvent{
+			Latency: v,
+		}))
+	}
+
+	s := &stream.StdDev{Name: "Latency"}
+	out := s.Apply(e)
+
+	fmt.Printf("%.4f\n", out[len(out)-1].ResultSet...)
+
+	// Output:
+	// 2.1381
+}
+
+func ExampleMedian() {
+	type LogEvent struct {
+		Level int
+	}
+
+	e := make([]stream.Event, 0)
+	for i := 0; i < 10; i++ {
+		e = append(e, stream.NewEvent(LogEvent{
+			Level: 9 - i,
+		}))
+	}
+
+	m := &stream.Median{Name: "Level"}
+	out := m.Apply(e)
+
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [4.5]
+}
+
+func ExamplePercentile() {
+	type LogEvent struct {
+		Latency int
+	}
+
+	e := make([]stream.Event, 0)
+	for i := 1; i <= 101; i++ {
+		e = append(e, stream.NewEvent(LogEvent{
+			Latency: i,
+		}))
+	}
+
+	p := &stream.Percentile{Name: "Latency", Percent: 99}
+	out := p.Apply(e)
+
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [100]
+}
+
+func TestPercentileRange(t *testing.T) {
+	type LogEvent struct {
+		Latency int
+	}
+
+	e := make([]stream.Event, 0)
+	for i := 1; i <= 10; i++ {
+		e = append(e, stream.NewEvent(LogEvent{Latency: i}))
+	}
+
+	cases := []struct {
+		in   stream.Accumulator
+		want float64
+	}{
+		{&stream.Percentile{Name: "Latency", Percent: -200}, 1},
+		{&stream.Percentile{Name: "Latency", Percent: 150}, 10},
+		{&stream.Percentile{Name: "Latency", Percent: math.NaN()}, 1},
+		{&stream.ApproxPercentile{Name: "Latency", Percent: -200, Accuracy: 0.01}, 1},
+		{&stream.ApproxPercentile{Name: "Latency", Percent: 150, Accuracy: 0.01}, 10},
+		{&stream.ApproxPercentile{Name: "Latency", Percent: 50}, 5},
+		{&stream.ApproxPercentile{Name: "Latency", Percent: 50, Accuracy: 1}, 5},
+		{&stream.ApproxPercentile{Name: "Latency", Percent: 50, Accuracy: -0.5}, 5},
+	}
+
+	for _, c := range cases {
+		for _, ev := range e {
+			c.in.Add(ev)
+		}
+
+		got := c.in.Result().(float64)
+		if math.Abs(got-c.want) > c.want*0.01 {
+			t.Errorf("%v: got=%v, want=%v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestApproxPercentile(t *testing.T) {
+	type LogEvent struct {
+		Latency float64
+	}
+
+	s := stream.New().
+		From(LogEvent{}).
+		Length(1000).
+		Percentile("Latency", 99).
+		ApproxPercentile("Latency", 99, 0.01)
+	defer s.Close()
+
+	for i := 0; i < 5000; i++ {
+		s.Listen(LogEvent{Latency: float64((i * 7919) % 10007)})
+		out := <-s.Output()
+
+		if i < 1000 {
+			continue
+		}
+
+		got := out[len(out)-1].ResultSet
+		exact, approx := got[0].(float64), got[1].(float64)
+		if math.Abs(approx-exact) > exact*0.01+1 {
+			t.Fatalf("i=%v, exact=%v, approx=%v", i, exact, approx)
+		}
+	}
+}
+
+func TestVariance(t *testing.T) {
+	type LogEvent struct {
+		Latency float64
+	}
+
+	s := stream.New().
+		From(LogEvent{}).
+		Length(5).
+		Variance("Latency")
+	defer s.Close()
+
+	in := []float64{1e9 + 4, 1e9 + 7, 1e9 + 13, 1e9 + 16, 1e9 + 4, 1e9 + 7, 1e9 + 13, 1e9 + 16}
+	for i, v := range in {
+		s.Listen(LogEvent{Latency: v})
+		out := <-s.Output()
+
+		w := in[max(0, i-4) : i+1]
+		var mean, want float64
+		for _, x := range w {
+			mean += x / float64(len(w))
+		}
+		for _, x := range w {
+			want += (x - mean) * (x - mean)
+		}
+		if len(w) > 1 {
+			want /= float64(len(w) - 1)
+		}
+
+		got := out[len(out)-1].ResultSet[0].(float64)
+		if math.Abs(got-want) > 1e-6 {
+			t.Errorf("i=%v, want=%v, got=%v", i, want, got)
+		}
+	}
+}
+
+func TestApproxCountDistinct(t *testing.T) {
+	type LogEvent struct {
+		UserID int
+	}
+
+	s := stream.New().
+		From(LogEvent{}).
+		Length(2000).
+		ApproxCountDistinct("UserID")
+	defer s.Close()
+
+	for i := 0; i < 5000; i++ {
+		s.Listen(LogEvent{UserID: i % 1500})
+		out := <-s.Output()
+
+		want := min(i+1, 1500)
+		got := out[len(out)-1].ResultSet[0].(int)
+		if math.Abs(float64(got-want)) > float64(want)*0.03 {
+			t.Fatalf("i=%v, want=%v, got=%v", i, want, got)
+		}
+	}
+}
+
+func ExampleTopK() {
+	type LogEvent struct {
+		Path string
+	}
+
+	e := make([]stream.Event, 0)
+	for i, p := range []string{"/", "/login", "/", "/logout", "/", "/login"} {
+		for j := 0; j <= i; j++ {
+			e = append(e, stream.NewEvent(LogEvent{
+				Path: p,
+			}))
+		}
+	}
+
+	k := &stream.TopK{Name: "Path", K: 2}
+	out := k.Apply(e)
+
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [[{/ 9} {/login 8}]]
+}
+
+func TestTopKExpire(t *testing.T) {
+	type LogEvent struct {
+		Path any
+	}
+
+	e := make([]stream.Event, 0)
+	for _, p := range []any{"/", "/", "/", "/login", "/login", 1, "1"} {
+		e = append(e, stream.NewEvent(LogEvent{Path: p}))
+	}
+
+	k := &stream.TopK{Name: "Path", K: 1}
+	for _, ev := range e {
+		k.Add(ev)
+	}
+
+	if got := fmt.Sprint(k.Result()); got != "[{/ 3}]" {
+		t.Errorf("got=%v", got)
+	}
+
+	// the next most frequent value takes the place of the expired one
+	for _, ev := range e[:3] {
+		k.Remove(ev)
+	}
+
+	if got := fmt.Sprint(k.Result()); got != "[{/login 2}]" {
+		t.Errorf("got=%v", got)
+	}
+
+	// 1 and "1" are different values
+	for _, ev := range e[3:5] {
+		k.Remove(ev)
+	}
+
+	k.K = 2
+	if got := fmt.Sprint(k.Result()); got != "[{1 1} {1 1}]" {
+		t.Errorf("got=%v", got)
+	}
+}
+
+func TestTopKNormalize(t *testing.T) {
+	type LogEvent struct {
+		Path any
+	}
+
+	cases := []struct {
+		in   []any
+		want string
+	}{
+		{[]any{1, int64(1), 1.0, int32(2)}, "[{1 3} {2 1}]"},
+		{[]any{uint(3), 3.0, 1.5, 1.5}, "[{1.5 2} {3 2}]"},
+		{[]any{[]int{1}, []int{1}, []int{2}}, "[{[1] 2} {[2] 1}]"},
+		{[]any{map[string]int{"a": 1}, map[string]int{"a": 1}}, "[{map[a:1] 2}]"},
+	}
+
+	for _, c := range cases {
+		k := &stream.TopK{Name: "Path", K: 2}
+		for _, p := range c.in {
+			k.Add(stream.NewEvent(LogEvent{Path: p}))
+		}
+
+		if got := fmt.Sprint(k.Result()); got != c.want {
+			t.Errorf("got=%v, want=%v", got, c.want)
+		}
+
+		for _, p := range c.in {
+			k.Remove(stream.NewEvent(LogEvent{Path: p}))
+		}
+
+		if got := fmt.Sprint(k.Result()); got != "[]" {
+			t.Errorf("got=%v", got)
+		}
+	}
+}
+
+func ExampleMaxBy() {
+	type LogEvent struct {
+		Latency int
+		Message string
+	}
+
+	e := make([]stream.Event, 0)
+	e = append(e, stream.NewEvent(LogEvent{Latency: 120, Message: "foo"}))
+	e = append(e, stream.NewEvent(LogEvent{Latency: 980, Message: "bar"}))
+	e = append(e, stream.NewEvent(LogEvent{Latency: 45, Message: "baz"}))
+
+	m := &stream.MaxBy{Name: "Message", By: "Latency"}
+	out := m.Apply(e)
+
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [bar]
+}
+
+func ExampleArrayAgg() {
+	type LogEvent struct {
+		Message string
+	}
+
+	e := make([]stream.Event, 0)
+	e = append(e, stream.NewEvent(LogEvent{Message: "foo"}))
+	e = append(e, stream.NewEvent(LogEvent{Message: "bar"}))
+
+	a := &stream.ArrayAgg{Name: "Message"}
+	out := a.Apply(e)
+
+	fmt.Printf("%#v\n", out[len(out)-1].ResultSet[0])
+
+	// Output:
+	// []string{"foo", "bar"}
+}
+
+func TestFirstLast(t *testing.T) {
+	type LogEvent struct {
+		Latency int
+		Message string
+	}
+
+	s := stream.New().
+		From(LogEvent{}).
+		Length(2).
+		First("Message").
+		Last("Message").
+		MaxBy("Message", "Latency").
+		MinBy("Message", "Latency")
+	defer s.Close()
+
+	cases := []struct {
+		in   LogEvent
+		want []any
+	}{
+		{LogEvent{3, "a"}, []any{"a", "a", "a", "a"}},
+		{LogEvent{5, "b"}, []any{"a", "b", "b", "a"}},
+		{LogEvent{1, "c"}, []any{"b", "c", "b", "c"}},
+		{LogEvent{2, "d"}, []any{"c", "d", "d", "c"}},
+	}
+
+	for _, c := range cases {
+		s.Listen(c.in)
+		out := <-s.Output()
+
+		got := out[len(out)-1].ResultSet
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("in=%v, want=%v, got=%v", c.in, c.want, got)
+		}
+	}
+}
+
+func TestMaxMin(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Latency time.Duration
+		Level   int8
+		Score   float64
+		Message string
+	}
+
+	now := time.Now()
+	in := []LogEvent{
+		{now.Add(2 * time.Second), 300 * time.Millisecond, -3, -1.5, "bar"},
+		{now, 100 * time.Millisecond, -1, -2.5, "foo"},
+		{now.Add(time.Second), 200 * time.Millisecond, -2, -0.5, "baz"},
+	}
+
+	cases := []struct {
+		name     string
+		max, min any
+	}{
+		{"Time", now.Add(2 * time.Second), now},
+		{"Latency", 300 * time.Millisecond, 100 * time.Millisecond},
+		{"Level", int8(-1), int8(-3)},
+		{"Score", -0.5, -2.5},
+		{"Message", "foo", "bar"},
+	}
+
+	events := func() []stream.Event {
+		e := make([]stream.Event, 0)
+		for _, v := range in {
+			e = append(e, stream.NewEvent(v))
+		}
+
+		return e
+	}
+
+	for _, c := range cases {
+		max := (&stream.Max{Name: c.name}).Apply(events())
+		if got := max[len(max)-1].ResultSet[0]; got != c.max {
+			t.Errorf("MAX(%v) want=%v, got=%v", c.name, c.max, got)
+		}
+
+		min := (&stream.Min{Name: c.name}).Apply(events())
+		if got := min[len(min)-1].ResultSet[0]; got != c.min {
+			t.Errorf("MIN(%v) want=%v, got=%v", c.name, c.min, got)
+		}
+	}
+}
+
+func TestSumCoercion(t *testing.T) {
+	type Level int16
+	type LogEvent struct {
+		Level   Level
+		Code    *uint32
+		Latency float32
+	}
+
+	e := make([]stream.Event, 0)
+	for i := 0; i < 4; i++ {
+		code := uint32(i * 100)
+		e = append(e, stream.NewEvent(LogEvent{
+			Level:   Level(i),
+			Code:    &code,
+			Latency: 0.5,
+		}))
+	}
+
+	cases := []struct {
+		name string
+		want float64
+	}{
+		{"Level", 6},
+		{"Code", 600},
+		{"Latency", 2},
+	}
+
+	for _, c := range cases {
+		out := (&stream.Sum{Name: c.name}).Apply(append(make([]stream.Event, 0), e...))
+		if got := out[len(out)-1].ResultSet[len(out[len(out)-1].ResultSet)-1]; got != c.want {
+			t.Errorf("SUM(%v) want=%v, got=%v", c.name, c.want, got)
+		}
+	}
+}
+
+// count is the state of a user-defined aggregate function
+// that counts the values larger than its argument.
+type count struct {
+	larger float64
+	n      int
+}
+
+func (c *count) Add(v any) {
+	if f, ok := v.(int); ok && float64(f) > c.larger {
+		c.n++
+	}
+}
+
+func (c *count) Remove(v any) {
+	if f, ok := v.(int); ok && float64(f) > c.larger {
+		c.n--
+	}
+}
+
+func (c *count) Result() any {
+	return c.n
+}
+
+func ExampleUserDefined() {
+	type LogEvent struct {
+		Level int
+	}
+
+	e := make([]stream.Event, 0)
+	for i := 0; i < 10; i++ {
+		e = append(e, stream.NewEvent(LogEvent{
+			Level: i,
+		}))
+	}
+
+	u := &stream.UserDefined{
+		Name: "count_larger",
+		Func: func(args ...any) stream.Aggregate { return &count{larger: args[0].(float64)} },
+		Expr: &stream.Field{Name: "Level"},
+		Args: []any{6.5},
+	}
+	out := u.Apply(e)
+
+	fmt.Println(u)
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// COUNT_LARGER(Level, 6.5)
+	// [3]
+}
@@ -1,11 +1,23 @@
 package stream
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
+
+var seq atomic.Uint64
 
 type Event struct {
 	Time       time.Time `json:"time"`
 	Underlying any       `json:"underlying"`
 	ResultSet  []any     `json:"result_set"`
+	seq        uint64
+}
+
+// Delta is the events inserted into the window as New and expired from it as Old on an event.
+type Delta struct {
+	New []Event `json:"new"`
+	Old []Event `json:"old"`
 }
 
 func NewEvent(input any) Event {
@@ -13,5 +25,6 @@ func NewEvent(input any) Event {
 		Time:       time.Now(),
 		Underlying: input,
 		ResultSet:  make([]any, 0),
+		seq:        seq.Add(1),
 	}
 }
@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Plan describes how a stream processes events, in the order they are processed.
+// It is marshaled into JSON with the field names in its tags.
+type Plan struct {
+	Query     string `json:"query"`
+	Source    Source `json:"source"`
+	Pattern   *Step  `json:"pattern,omitempty"`
+	Where     []Step `json:"where"`
+	Window    *Step  `json:"window,omitempty"`
+	Match     *Step  `json:"match_recognize,omitempty"`
+	Select    []Step `json:"select"`
+	Aggregate []Step `json:"aggregate"`
+	OrderBy   *Step  `json:"order_by,omitempty"`
+	Limit     *Step  `json:"limit,omitempty"`
+	Output    *Step  `json:"output,omitempty"`
+}
+
+// Source is the type of events a stream accepts.
+// Type is the Go type of the events, or map[string]any for a Schema.
+type Source struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Step is a component of a stream such as a where predicate or an aggregate function.
+// Kind is the type of the component, e.g. LargerThan or Average.
+type Step struct {
+	Kind   string       `json:"kind"`
+	Expr   string       `json:"expr"`
+	Fields []FieldIndex `json:"fields,omitempty"`
+}
+
+// FieldIndex is a field of events accessed by a step.
+// Index is the index sequence of the struct field it is resolved to,
+// and is empty if the field is looked up by name when an event arrives.
+// Type is empty if it is known only when an event arrives.
+type FieldIndex struct {
+	Name  string `json:"name"`
+	Index []int  `json:"index,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// Explain returns the plan of the stream.
+func (s *Stream) Explain() *Plan {
+	p := &Plan{
+		Query:     s.String(),
+		Where:     make([]Step, 0),
+		Select:    make([]Step, 0),
+		Aggregate: make([]Step, 0),
+	}
+
+	if s.from != nil {
+		p.Source = Source{
+			Name: From{Type: s.from}.String(),
+			Type: reflect.TypeOf(s.from).String(),
+		}
+
+		if _, ok := s.from.(Schema); ok {
+			p.Source.Type = reflect.TypeOf(map[string]any{}).String()
+		}
+	}
+
+	if s.pattern != nil {
+		pt := s.step(s.pattern)
+		p.Pattern = &pt
+	}
+
+	for _, w := range s.where {
+		if _, ok := w.(From); ok {
+			continue
+		}
+
+		p.Where = append(p.Where, s.step(w))
+	}
+
+	if s.window != nil {
+		w := s.step(s.window)
+		p.Window = &w
+	}
+
+	if s.recognize != nil {
+		m := s.step(s.recognize)
+		p.Match = &m
+	}
+
+	for _, sl := range s.selector {
+		p.Select = append(p.Select, s.step(sl))
+	}
+
+	for _, a := range s.aggregator {
+		p.Aggregate = append(p.Aggregate, s.step(a))
+	}
+
+	if _, ok := s.orderby.(*NoOrder); !ok {
+		o := s.step(s.orderby)
+		p.OrderBy = &o
+	}
+
+	if _, ok := s.limit.(*NoLimit); !ok {
+		l := s.step(s.limit)
+		p.Limit = &l
+	}
+
+	if s.rate != nil {
+		r := s.step(s.rate)
+		p.Output = &r
+	}
+
+	return p
+}
+
+// step returns the step of the component c with the fields it accesses,
+// as they were resolved when c was bound to the stream.
+func (s *Stream) step(c fmt.Stringer) Step {
+	st := Step{
+		Kind: indirect(reflect.ValueOf(c)).Type().Name(),
+		Expr: c.String(),
+	}
+
+	if b, ok := c.(binder); ok && len(s.fields[b]) > 0 {
+		st.Fields = s.fields[b]
+	}
+
+	return st
+}
+
+// String returns the plan as an indented text.
+func (p *Plan) String() string {
+	var buf strings.Builder
+
+	buf.WriteString(fmt.Sprintf("Query: %v\n", p.Query))
+	buf.WriteString(fmt.Sprintf("Source: %v (%v)\n", p.Source.Name, p.Source.Type))
+
+	steps := func(title string, st ...Step) {
+		if len(st) == 0 {
+			return
+		}
+
+		buf.WriteString(fmt.Sprintf("%v:\n", title))
+		for _, s := range st {
+			buf.WriteString(fmt.Sprintf("  %v: %v\n", s.Kind, s.Expr))
+			for _, f := range s.Fields {
+				buf.WriteString(fmt.Sprintf("    %v", f.Name))
+				if len(f.Index) > 0 {
+					buf.WriteString(fmt.Sprintf(" index=%v", f.Index))
+				}
+
+				if f.Type != "" {
+					buf.WriteString(fmt.Sprintf(" type=%v", f.Type))
+				}
+
+				buf.WriteString("\n")
+			}
+		}
+	}
+
+	if p.Pattern != nil {
+		steps("Pattern", *p.Pattern)
+	}
+
+	steps("Where", p.Where...)
+	if p.Window != nil {
+		steps("Window", *p.Window)
+	}
+
+	if p.Match != nil {
+		steps("MatchRecognize", *p.Match)
+	}
+
+	steps("Select", p.Select...)
+	steps("Aggregate", p.Aggregate...)
+	if p.OrderBy != nil {
+		steps("OrderBy", *p.OrderBy)
+	}
+
+	if p.Limit != nil {
+		steps("Limit", *p.Limit)
+	}
+
+	if p.Output != nil {
+		steps("Output", *p.Output)
+	}
+
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// JSON returns the plan as an indented JSON.
+// Operators such as > in expressions are not escaped.
+func (p *Plan) JSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,70 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/itsubaki/gostream/stream"
+)
+
+func ExampleStream_Explain() {
+	schema := stream.NewSchema("LogEvent", map[string]any{
+		"level":   0,
+		"message": "",
+	})
+
+	s := stream.New().
+		From(schema).
+		Length(10).
+		Select("message").
+		MaxBy("message", "level").
+		LargerThan("level", 2)
+
+	fmt.Println(s.Explain())
+
+	// Output:
+	// Query: SELECT message, MAX_BY(message, level) FROM LogEvent.LENGTH(10) WHERE level > 2
+	// Source: LogEvent (map[string]interface {})
+	// Where:
+	//   LargerThan: level > 2
+	//     level type=int
+	// Window:
+	//   Length: LENGTH(10)
+	// Select:
+	//   Select: message
+	//     message type=string
+	// Aggregate:
+	//   MaxBy: MAX_BY(message, level)
+	//     message type=string
+	//     level type=int
+}
+
+func TestExplainRunning(t *testing.T) {
+	type LogEvent struct {
+		Level   int
+		Message string
+	}
+
+	s := stream.New().
+		Select("Message").
+		Average("Level").
+		From(LogEvent{}).
+		Length(10).
+		LargerThan("Level", -1).
+		OrderBy("Level", true)
+	go s.Run()
+
+	want := s.Explain().String()
+	for i := 0; i < 100; i++ {
+		s.Input() <- LogEvent{Level: i, Message: "foo"}
+		if got := s.Explain().String(); got != want {
+			t.Fatalf("got=%v, want=%v", got, want)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		<-s.Output()
+	}
+
+	s.Close()
+}
@@ -0,0 +1,327 @@
+package stream
+
+import (
+	"reflect"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+)
+
+var (
+	_ Expr = (*Field)(nil)
+	_ Expr = (*Value)(nil)
+	_ Expr = (*Call)(nil)
+	_ Expr = (*Compare)(nil)
+	_ Expr = (*Case)(nil)
+)
+
+var (
+	_ binder = (*Field)(nil)
+	_ binder = (*Call)(nil)
+	_ binder = (*Compare)(nil)
+	_ binder = (*Case)(nil)
+)
+
+// Expr is an expression evaluated on an event such as a field, a value or a function call.
+// Eval returns nil for NULL, e.g. if the event has no such field.
+type Expr interface {
+	Eval(input any) any
+	String() string
+}
+
+// Field is the value of the named field of an event.
+type Field struct {
+	Name  string
+	Index []int
+}
+
+func (x *Field) Eval(input any) any {
+	v, _ := field(input, x.Name, x.Index)
+	return v
+}
+
+func (x *Field) bind(r resolver) {
+	x.Index = r(x.Name)
+}
+
+func (x *Field) String() string {
+	return node(x).String()
+}
+
+// Value is a constant value.
+type Value struct {
+	Value any
+}
+
+func (x *Value) Eval(input any) any {
+	return x.Value
+}
+
+func (x *Value) String() string {
+	return node(x).String()
+}
+
+// Call is a call of the scalar function Func, a Go function, by Name such as UPPER(Message).
+// The arguments are converted to the types of the parameters of Func.
+// The result is NULL if an argument cannot be converted, e.g. a NULL for a parameter that is not nullable,
+// or if Func returns a non-nil error as its second result.
+type Call struct {
+	Name string
+	Func any
+	Args []Expr
+}
+
+func (x *Call) Eval(input any) any {
+	fn := reflect.ValueOf(x.Func)
+	t := fn.Type()
+
+	args := make([]reflect.Value, len(x.Args))
+	for i, a := range x.Args {
+		v, ok := convert(a.Eval(input), ArgType(t, i))
+		if !ok {
+			return nil
+		}
+
+		args[i] = v
+	}
+
+	out := fn.Call(args)
+	if len(out) > 1 && !out[1].IsNil() {
+		return nil
+	}
+
+	return out[0].Interface()
+}
+
+func (x *Call) bind(r resolver) {
+	for _, a := range x.Args {
+		if b, ok := a.(binder); ok {
+			b.bind(r)
+		}
+	}
+}
+
+func (x *Call) String() string {
+	return node(x).String()
+}
+
+// Compare is a comparison of two expressions with >, < or = such as UPPER(Message) = 'GET'.
+// It is a Where as well as an Expr of type bool.
+type Compare struct {
+	Op lexer.Token
+	X  Expr
+	Y  Expr
+}
+
+func (x *Compare) Eval(input any) any {
+	return x.Apply(input)
+}
+
+func (x *Compare) Apply(input any) bool {
+	a, b := x.X.Eval(input), x.Y.Eval(input)
+	switch x.Op {
+	case lexer.LARGER:
+		c, ok := compare(a, b)
+		return ok && c > 0
+	case lexer.LESS:
+		c, ok := compare(a, b)
+		return ok && c < 0
+	case lexer.EQUALS:
+		return equal(a, b)
+	}
+
+	return false
+}
+
+func (x *Compare) bind(r resolver) {
+	for _, a := range []Expr{x.X, x.Y} {
+		if b, ok := a.(binder); ok {
+			b.bind(r)
+		}
+	}
+}
+
+func (x *Compare) String() string {
+	return node(x).String()
+}
+
+// Case is the value of Then of the first When whose Cond is true,
+// or equals Value if it is not nil, such as CASE WHEN Latency > 500 THEN 'slow' ELSE 'ok' END.
+// It is the value of Else, or NULL if Else is nil, if there is no such When.
+type Case struct {
+	Value Expr
+	When  []When
+	Else  Expr
+}
+
+// When is a WHEN Cond THEN Then clause of a Case.
+type When struct {
+	Cond Expr
+	Then Expr
+}
+
+func (x *Case) Eval(input any) any {
+	var v any
+	if x.Value != nil {
+		v = x.Value.Eval(input)
+	}
+
+	for _, w := range x.When {
+		c := w.Cond.Eval(input)
+		if x.Value != nil && equal(v, c) || x.Value == nil && c == true {
+			return w.Then.Eval(input)
+		}
+	}
+
+	if x.Else == nil {
+		return nil
+	}
+
+	return x.Else.Eval(input)
+}
+
+func (x *Case) bind(r resolver) {
+	for _, a := range x.exprs() {
+		if b, ok := a.(binder); ok {
+			b.bind(r)
+		}
+	}
+}
+
+// exprs returns the expressions of x that are not nil.
+func (x *Case) exprs() []Expr {
+	out := make([]Expr, 0)
+	if x.Value != nil {
+		out = append(out, x.Value)
+	}
+
+	for _, w := range x.When {
+		out = append(out, w.Cond, w.Then)
+	}
+
+	if x.Else != nil {
+		out = append(out, x.Else)
+	}
+
+	return out
+}
+
+func (x *Case) String() string {
+	return node(x).String()
+}
+
+// ArgType returns the type of the i-th parameter of the function type t,
+// which is the element type of the last parameter for the arguments it takes if t is variadic.
+func ArgType(t reflect.Type, i int) reflect.Type {
+	if t.IsVariadic() && i >= t.NumIn()-1 {
+		return t.In(t.NumIn() - 1).Elem()
+	}
+
+	return t.In(i)
+}
+
+// convert returns v as a value of type t.
+// Numbers are converted to any numeric type, and strings and bools to types of the same kind.
+// It returns false if v is nil and t is not nullable, or v has another type.
+func convert(v any, t reflect.Type) (reflect.Value, bool) {
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			return reflect.Zero(t), true
+		}
+
+		return reflect.Value{}, false
+	}
+
+	if rv := reflect.ValueOf(v); rv.Type().AssignableTo(t) {
+		return rv, true
+	}
+
+	switch n := normalize(v).(type) {
+	case int64, uint64, float64:
+		if numeric(t.Kind()) {
+			return reflect.ValueOf(n).Convert(t), true
+		}
+	case string, bool:
+		if reflect.TypeOf(n).Kind() == t.Kind() {
+			return reflect.ValueOf(n).Convert(t), true
+		}
+	case nil:
+		return convert(nil, t)
+	}
+
+	return reflect.Value{}, false
+}
+
+// Convertible reports whether convert accepts the values of type t for type param, other than nil.
+// Values of an interface type are known only when they are converted.
+func Convertible(t, param reflect.Type) bool {
+	if t.AssignableTo(param) || param.Kind() == reflect.Interface {
+		return true
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch k := t.Kind(); {
+	case k == reflect.Interface || t == param:
+		return true
+	case numeric(k):
+		return numeric(param.Kind())
+	case k == reflect.String || k == reflect.Bool:
+		return k == param.Kind()
+	}
+
+	return false
+}
+
+// numeric reports whether k is a kind of integer or float.
+func numeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+// node returns the syntax tree of x.
+func node(x Expr) ast.Expr {
+	switch x := x.(type) {
+	case *Field:
+		return ast.Field(x.Name)
+	case *Value:
+		return literal(x.Value)
+	case *Call:
+		args := make([]ast.Expr, len(x.Args))
+		for i, a := range x.Args {
+			args[i] = node(a)
+		}
+
+		return &ast.Call{Name: x.Name, Args: args}
+	case *Compare:
+		return &ast.BinaryExpr{Op: x.Op, X: node(x.X), Y: node(x.Y)}
+	case *Navigate:
+		return &ast.Call{Func: x.Func, Args: []ast.Expr{node(x.X)}}
+	case *Case:
+		c := &ast.Case{When: make([]ast.When, len(x.When))}
+		if x.Value != nil {
+			c.Value = node(x.Value)
+		}
+
+		for i, w := range x.When {
+			c.When[i] = ast.When{Cond: node(w.Cond), Then: node(w.Then)}
+		}
+
+		if x.Else != nil {
+			c.Else = node(x.Else)
+		}
+
+		return c
+	}
+
+	return &ast.Ident{Name: x.String()}
+}
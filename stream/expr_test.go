@@ -0,0 +1,124 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/itsubaki/gostream/lexer"
+	"github.com/itsubaki/gostream/stream"
+)
+
+func ExampleCall() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	upper, _ := stream.Builtin("upper")
+	s := stream.New().
+		SelectExpr(&stream.Call{Name: "upper", Func: upper, Args: []stream.Expr{&stream.Field{Name: "Message"}}}).
+		From(LogEvent{}).
+		Length(10)
+	defer s.Close()
+
+	s.Listen(LogEvent{Level: 1, Message: "foo"})
+	out := <-s.Output()
+
+	fmt.Println(s)
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// SELECT UPPER(Message) FROM LogEvent.LENGTH(10)
+	// [FOO]
+}
+
+func ExampleCase() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	s := stream.New().
+		SelectExpr(&stream.Case{
+			When: []stream.When{
+				{Cond: &stream.Compare{Op: lexer.LARGER, X: &stream.Field{Name: "Level"}, Y: &stream.Value{Value: 2}}, Then: &stream.Value{Value: "high"}},
+			},
+			Else: &stream.Value{Value: "low"},
+		}).
+		As("severity").
+		From(LogEvent{}).
+		Length(10)
+	defer s.Close()
+
+	s.Listen(LogEvent{Level: 3})
+	s.Listen(LogEvent{Level: 1})
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT CASE WHEN Level > 2 THEN 'high' ELSE 'low' END AS severity FROM LogEvent.LENGTH(10)
+	// [high]
+	// [low]
+}
+
+func TestBuiltin(t *testing.T) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+		Ratio   *float64
+	}
+
+	ts := time.Date(2024, 1, 3, 4, 5, 6, 7, time.UTC)
+	e := LogEvent{Time: ts, Level: -2, Message: "héllo"}
+
+	field := func(name string) stream.Expr { return &stream.Field{Name: name} }
+	value := func(v any) stream.Expr { return &stream.Value{Value: v} }
+
+	cases := []struct {
+		name string
+		args []stream.Expr
+		want any
+	}{
+		{"upper", []stream.Expr{field("Message")}, "HÉLLO"},
+		{"lower", []stream.Expr{value("ABC")}, "abc"},
+		{"length", []stream.Expr{field("Message")}, 5},
+		{"substr", []stream.Expr{field("Message"), value(2)}, "éllo"},
+		{"substr", []stream.Expr{field("Message"), value(2), value(3)}, "éll"},
+		{"substr", []stream.Expr{field("Message"), value(0), value(10)}, "héllo"},
+		{"substr", []stream.Expr{field("Message"), value(9)}, ""},
+		{"concat", []stream.Expr{field("Message"), value(":"), field("Level"), field("Ratio")}, "héllo:-2"},
+		{"abs", []stream.Expr{field("Level")}, 2.0},
+		{"round", []stream.Expr{value(2.5)}, 3.0},
+		{"round", []stream.Expr{value(1.2345), value(2)}, 1.23},
+		{"floor", []stream.Expr{value(-0.5)}, -1.0},
+		{"coalesce", []stream.Expr{field("Ratio"), field("Nope"), value(0)}, 0},
+		{"if", []stream.Expr{&stream.Compare{Op: lexer.LESS, X: field("Level"), Y: value(0)}, value("neg"), value("pos")}, "neg"},
+		{"date_trunc", []stream.Expr{value("minute"), field("Time")}, time.Date(2024, 1, 3, 4, 5, 0, 0, time.UTC)},
+		{"date_trunc", []stream.Expr{value("days"), field("Time")}, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"date_trunc", []stream.Expr{value("week"), field("Time")}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"date_trunc", []stream.Expr{value("year"), field("Time")}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"date_trunc", []stream.Expr{value("century"), field("Time")}, nil},
+		{"upper", []stream.Expr{field("Ratio")}, nil},
+		{"upper", []stream.Expr{field("Level")}, nil},
+	}
+
+	for _, c := range cases {
+		fn, ok := stream.Builtin(c.name)
+		if !ok {
+			t.Fatalf("%v: not found", c.name)
+		}
+
+		x := &stream.Call{Name: c.name, Func: fn, Args: c.args}
+		if got := x.Eval(e); got != c.want {
+			t.Errorf("%v: want=%v, got=%v", x, c.want, got)
+		}
+	}
+}
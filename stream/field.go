@@ -0,0 +1,211 @@
+package stream
+
+import (
+	"reflect"
+	"strings"
+)
+
+// resolver returns the index path of the named field of the type of events in a stream.
+type resolver func(name string) []int
+
+// binder is implemented by the parts of a query that access fields of an event.
+// bind compiles the field names into index paths with r,
+// so that they are not looked up by name on every event.
+type binder interface {
+	bind(r resolver)
+}
+
+// Naming configures how the field names in a query are resolved to struct fields.
+// The zero value matches Go field names exactly.
+type Naming struct {
+	// Tags are the struct tags that name a field, looked up in order,
+	// e.g. []string{"gostream", "json"} for `json:"latency_ms"`.
+	// The Go field name is accepted as well.
+	Tags []string
+
+	// CaseInsensitive matches field names regardless of case.
+	CaseInsensitive bool
+}
+
+// Path splits a field name into its dotted path, e.g. Req.Header.UserAgent.
+// Escaped segments such as `Time` are unescaped.
+func Path(name string) []string {
+	out := strings.Split(name, ".")
+	for i := range out {
+		out[i] = strings.Trim(out[i], "`")
+	}
+
+	return out
+}
+
+// index returns the index path of the named field of t.
+// The name may be a dotted path into nested structs, and promoted fields of embedded structs are found as well.
+// It returns nil if t is not a struct or has no such field.
+func (n Naming) index(t reflect.Type, name string) []int {
+	out := make([]int, 0)
+	for _, p := range Path(name) {
+		for t != nil && t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		if t == nil || t.Kind() != reflect.Struct {
+			return nil
+		}
+
+		f, ok := n.lookup(t, p)
+		if !ok {
+			return nil
+		}
+
+		out = append(out, f.Index...)
+		t = f.Type
+	}
+
+	return out
+}
+
+// Type returns the type of the named field of events of from, a struct or a Schema.
+// It returns false if there is no such field.
+// The type is nil if the field exists but its type is known only when an event arrives,
+// e.g. a key of a map[string]any.
+func (n Naming) Type(from any, name string) (reflect.Type, bool) {
+	p := Path(name)
+
+	t := reflect.TypeOf(from)
+	if s, ok := from.(Schema); ok {
+		// keys of map events are matched exactly
+		f, ok := s.Fields[p[0]]
+		if !ok {
+			return nil, false
+		}
+
+		t, p = f, p[1:]
+	}
+
+	for _, seg := range p {
+		for t != nil && t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		if t == nil {
+			return nil, true
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			f, ok := n.lookup(t, seg)
+			if !ok {
+				return nil, false
+			}
+
+			t = f.Type
+		case reflect.Map, reflect.Interface:
+			return nil, true
+		default:
+			return nil, false
+		}
+	}
+
+	return t, true
+}
+
+// lookup returns the exported field of t named name.
+// A name given by a struct tag takes precedence over a Go field name.
+func (n Naming) lookup(t reflect.Type, name string) (reflect.StructField, bool) {
+	if len(n.Tags) == 0 && !n.CaseInsensitive {
+		f, ok := t.FieldByName(name)
+		return f, ok && f.IsExported()
+	}
+
+	fields := reflect.VisibleFields(t)
+	for _, f := range fields {
+		if f.IsExported() && n.tag(f) == name {
+			return f, true
+		}
+	}
+
+	if f, ok := t.FieldByName(name); ok && f.IsExported() {
+		return f, true
+	}
+
+	if !n.CaseInsensitive {
+		return reflect.StructField{}, false
+	}
+
+	for _, f := range fields {
+		if f.IsExported() && (strings.EqualFold(n.tag(f), name) || strings.EqualFold(f.Name, name)) {
+			return f, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// tag returns the name of f given by the first of the struct tags present, or "" if there is none.
+func (n Naming) tag(f reflect.StructField) string {
+	for _, k := range n.Tags {
+		v, ok := f.Tag.Lookup(k)
+		if !ok {
+			continue
+		}
+
+		name, _, _ := strings.Cut(v, ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		return name
+	}
+
+	return ""
+}
+
+// field returns the named field of input.
+// input is a struct, or a map[string]any such as decoded JSON, and may nest both.
+// The compiled index path is used if there is one, otherwise the field is looked up by name.
+// It returns false if there is no such exported field or a nil pointer is on the way.
+func field(input any, name string, index []int) (any, bool) {
+	v := indirect(reflect.ValueOf(input))
+	if len(index) > 0 && v.Kind() == reflect.Struct {
+		f, err := v.FieldByIndexErr(index)
+		if err != nil {
+			return nil, false
+		}
+
+		return f.Interface(), true
+	}
+
+	for _, p := range Path(name) {
+		switch v = indirect(v); v.Kind() {
+		case reflect.Struct:
+			if f, ok := v.Type().FieldByName(p); !ok || !f.IsExported() {
+				return nil, false
+			}
+
+			v = v.FieldByName(p)
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+
+			v = v.MapIndex(reflect.ValueOf(p).Convert(v.Type().Key()))
+		default:
+			return nil, false
+		}
+
+		if !v.IsValid() {
+			return nil, false
+		}
+	}
+
+	return v.Interface(), true
+}
+
+// indirect dereferences pointers and interfaces in v until it reaches a value or nil.
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	return v
+}
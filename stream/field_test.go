@@ -0,0 +1,113 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/itsubaki/gostream/stream"
+)
+
+func ExampleStream_nested() {
+	type Header struct {
+		UserAgent string
+	}
+
+	type Request struct {
+		Header  *Header
+		Latency int
+	}
+
+	type Meta struct {
+		Host string
+	}
+
+	type LogEvent struct {
+		Meta
+		Time time.Time
+		Req  Request
+	}
+
+	s := stream.New().
+		Select("Req.Header.UserAgent").
+		Select("Host").
+		Max("Req.Latency").
+		From(LogEvent{}).
+		Length(10).
+		LargerThan("Req.Latency", 100).
+		OrderBy("Meta.Host", stream.ASC)
+	defer s.Close()
+
+	s.Listen(LogEvent{
+		Meta: Meta{Host: "localhost"},
+		Req: Request{
+			Header:  &Header{UserAgent: "curl"},
+			Latency: 150,
+		},
+	})
+
+	out := <-s.Output()
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [curl localhost 150]
+}
+
+func TestNaming(t *testing.T) {
+	type Request struct {
+		Latency int `json:"latency_ms"`
+	}
+
+	type LogEvent struct {
+		Level   int    `gostream:"lvl" json:"level"`
+		Message string `json:"msg,omitempty"`
+		Host    string `json:"-"`
+		Req     Request
+		secret  string
+	}
+
+	in := LogEvent{Level: 1, Message: "foo", Host: "localhost", Req: Request{Latency: 100}, secret: "bar"}
+
+	cases := []struct {
+		naming stream.Naming
+		name   string
+		want   any
+	}{
+		{stream.Naming{}, "Level", 1},
+		{stream.Naming{}, "level", nil},
+		{stream.Naming{Tags: []string{"gostream", "json"}}, "lvl", 1},
+		{stream.Naming{Tags: []string{"gostream", "json"}}, "level", nil},
+		{stream.Naming{Tags: []string{"json"}}, "level", 1},
+		{stream.Naming{Tags: []string{"json"}}, "msg", "foo"},
+		{stream.Naming{Tags: []string{"json"}}, "Message", "foo"},
+		{stream.Naming{Tags: []string{"json"}}, "Host", "localhost"},
+		{stream.Naming{Tags: []string{"json"}}, "Req.latency_ms", 100},
+		{stream.Naming{CaseInsensitive: true}, "message", "foo"},
+		{stream.Naming{CaseInsensitive: true}, "req.LATENCY", 100},
+		{stream.Naming{Tags: []string{"json"}, CaseInsensitive: true}, "MSG", "foo"},
+		{stream.Naming{}, "secret", nil},
+		{stream.Naming{Tags: []string{"json"}}, "secret", nil},
+		{stream.Naming{CaseInsensitive: true}, "Secret", nil},
+	}
+
+	for _, c := range cases {
+		s := stream.New().
+			Naming(c.naming).
+			Select(c.name).
+			From(LogEvent{}).
+			Length(1)
+
+		s.Listen(in)
+		out := <-s.Output()
+		s.Close()
+
+		var got any
+		if rs := out[len(out)-1].ResultSet; len(rs) > 0 {
+			got = rs[0]
+		}
+
+		if got != c.want {
+			t.Errorf("%+v %v: want=%v, got=%v", c.naming, c.name, c.want, got)
+		}
+	}
+}
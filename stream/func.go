@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// builtin are the built-in scalar functions by name.
+var builtin = map[string]any{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"length":     length,
+	"substr":     substr,
+	"concat":     concat,
+	"abs":        math.Abs,
+	"round":      round,
+	"floor":      math.Floor,
+	"coalesce":   coalesce,
+	"if":         iif,
+	"date_trunc": trunc,
+	"now":        time.Now,
+}
+
+// Builtin returns the built-in scalar function of the name in lower case,
+// a Go function that is called by Call.
+func Builtin(name string) (any, bool) {
+	fn, ok := builtin[name]
+	return fn, ok
+}
+
+// ValidateArg returns an error if the constant v cannot be the i-th argument of the built-in function name,
+// such as an unknown unit of DATE_TRUNC, so that it is reported before an event arrives.
+func ValidateArg(name string, i int, v any) error {
+	if name != "date_trunc" || i != 0 {
+		return nil
+	}
+
+	unit, ok := v.(string)
+	if !ok {
+		return nil
+	}
+
+	if _, err := trunc(unit, time.Time{}); err != nil {
+		return fmt.Errorf("%w %v, expected millisecond, second, minute, hour, day, week, month or year", err, unit)
+	}
+
+	return nil
+}
+
+// length returns the number of characters in s.
+func length(s string) int {
+	return len([]rune(s))
+}
+
+// substr returns the characters of s from start, counted from 1,
+// up to the end of s or of the given length.
+func substr(s string, start int, length ...int) string {
+	r := []rune(s)
+
+	begin := min(max(start-1, 0), len(r))
+	end := len(r)
+	if len(length) > 0 {
+		end = min(max(start-1+length[0], begin), len(r))
+	}
+
+	return string(r[begin:end])
+}
+
+// concat returns the values concatenated as strings. NULL values are skipped.
+func concat(v ...any) string {
+	var buf strings.Builder
+	for _, vv := range v {
+		if normalize(vv) == nil {
+			continue
+		}
+
+		buf.WriteString(fmt.Sprint(vv))
+	}
+
+	return buf.String()
+}
+
+// round returns x rounded half away from zero to the given number of decimal places, 0 by default.
+func round(x float64, places ...int) float64 {
+	if len(places) == 0 {
+		return math.Round(x)
+	}
+
+	p := math.Pow10(places[0])
+	return math.Round(x*p) / p
+}
+
+// coalesce returns the first value that is not NULL.
+func coalesce(v ...any) any {
+	for _, vv := range v {
+		if normalize(vv) != nil {
+			return vv
+		}
+	}
+
+	return nil
+}
+
+// iif returns then if cond is true, otherwise els.
+func iif(cond bool, then, els any) any {
+	if cond {
+		return then
+	}
+
+	return els
+}
+
+var errUnit = errors.New("unknown unit")
+
+// trunc returns t truncated to the unit such as 'minute' or 'day' in its location.
+// Weeks start on Monday.
+func trunc(unit string, t time.Time) (time.Time, error) {
+	y, m, d := t.Date()
+	switch strings.TrimSuffix(strings.ToLower(unit), "s") {
+	case "millisecond":
+		return t.Truncate(time.Millisecond), nil
+	case "second":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, t.Location()), nil
+	case "minute":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "hour":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location()), nil
+	case "day":
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location()), nil
+	case "week":
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location()), nil
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location()), nil
+	case "year":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	}
+
+	return time.Time{}, errUnit
+}
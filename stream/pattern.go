@@ -0,0 +1,173 @@
+package stream
+
+import (
+	"maps"
+	"time"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+)
+
+// Match is the events of a match of a Pattern by the tags of its steps,
+// such as a and b of EVERY a=LoginFail -> b=LoginOK.
+// The fields of the events are accessed by a dotted path such as a.User,
+// and SELECT * selects the events in the order of their tags as of a schema-less event.
+type Match = map[string]any
+
+// Pattern matches the sequences of events of Steps, in which the event of each step is followed by the event of the next step.
+// Each partial match is a state machine that waits for the event of its next step,
+// and events of other types or for which the conditions are false are skipped.
+// Every starts a match at each event of the first step, otherwise the pattern matches only once.
+// Within is the time from the first to the last event of a match, or 0 for no limit,
+// which is written in Unit as Time is.
+// Max is the number of partial matches kept, DefaultMaxPartial if it is 0.
+// When more are started, e.g. by EVERY without WITHIN on a long-running stream, the oldest are dropped,
+// so that the memory is bounded whether or not they expire.
+type Pattern struct {
+	Every   bool
+	Steps   []PatternStep
+	Within  time.Duration
+	Unit    lexer.Token
+	Max     int
+	partial []*partial
+	started bool
+}
+
+// DefaultMaxPartial is the number of partial matches a Pattern keeps by default.
+const DefaultMaxPartial = 10000
+
+// PatternStep is an event of a Pattern of the type From, tagged Tag, for which the conditions of Where are true.
+// The conditions are evaluated on the Match of the events so far with the event tagged Tag,
+// e.g. b.User = a.User. Tag is the name of the type From if it is empty.
+type PatternStep struct {
+	Tag   string
+	From  any
+	Where []Expr
+}
+
+// partial is a match waiting for the event of the step next, which started at start.
+type partial struct {
+	match Match
+	next  int
+	start time.Time
+}
+
+// Apply returns the matches that the event e completes, in the order they started.
+func (p *Pattern) Apply(e Event) []Match {
+	out := make([]Match, 0)
+	if len(p.Steps) == 0 {
+		return out
+	}
+
+	rest := make([]*partial, 0)
+	for _, m := range p.partial {
+		if p.Within > 0 && e.Time.Sub(m.start) > p.Within {
+			// expired
+			continue
+		}
+
+		step := p.Steps[m.next]
+		if !step.Apply(m.match, e.Underlying) {
+			rest = append(rest, m)
+			continue
+		}
+
+		m.match[step.tag()] = e.Underlying
+		if m.next++; m.next < len(p.Steps) {
+			rest = append(rest, m)
+			continue
+		}
+
+		out = append(out, m.match)
+	}
+	p.partial = rest
+
+	// the event that starts a match is not an event of its next step
+	if p.started && !p.Every {
+		return out
+	}
+
+	if !p.Steps[0].Apply(Match{}, e.Underlying) {
+		return out
+	}
+	p.started = true
+
+	m := &partial{
+		match: Match{p.Steps[0].tag(): e.Underlying},
+		next:  1,
+		start: e.Time,
+	}
+
+	if len(p.Steps) == 1 {
+		return append(out, m.match)
+	}
+
+	p.partial = append(p.partial, m)
+
+	max := p.Max
+	if max <= 0 {
+		max = DefaultMaxPartial
+	}
+
+	if len(p.partial) > max {
+		// drop the oldest
+		p.partial = p.partial[len(p.partial)-max:]
+	}
+
+	return out
+}
+
+func (p *Pattern) String() string {
+	return p.node().String()
+}
+
+// node returns the syntax tree of p.
+func (p *Pattern) node() *ast.Pattern {
+	x := &ast.Pattern{
+		Every: p.Every,
+		Steps: make([]ast.Step, len(p.Steps)),
+	}
+
+	for i, s := range p.Steps {
+		x.Steps[i] = ast.Step{Tag: s.Tag, From: From{Type: s.From}.String()}
+		for _, w := range s.Where {
+			x.Steps[i].Where = ast.And(x.Steps[i].Where, node(w))
+		}
+	}
+
+	if p.Within > 0 {
+		x.Within = ast.Split(p.Within, p.Unit)
+	}
+
+	return x
+}
+
+// Apply reports whether input is an event of the step, given the match m of the events so far.
+func (s PatternStep) Apply(m Match, input any) bool {
+	if !(From{Type: s.From}).Apply(input) {
+		return false
+	}
+
+	if len(s.Where) == 0 {
+		return true
+	}
+
+	c := maps.Clone(m)
+	c[s.tag()] = input
+	for _, w := range s.Where {
+		if v, ok := w.Eval(c).(bool); !ok || !v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tag returns the tag of the event of the step in a Match.
+func (s PatternStep) tag() string {
+	if s.Tag == "" {
+		return From{Type: s.From}.String()
+	}
+
+	return s.Tag
+}
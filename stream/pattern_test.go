@@ -0,0 +1,125 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/itsubaki/gostream/lexer"
+	"github.com/itsubaki/gostream/stream"
+)
+
+type LoginFail struct {
+	User string
+}
+
+type LoginOK struct {
+	User string
+}
+
+func ExamplePattern() {
+	s := stream.New().
+		Select("a.User").
+		Pattern(&stream.Pattern{
+			Every: true,
+			Steps: []stream.PatternStep{
+				{Tag: "a", From: LoginFail{}},
+				{Tag: "b", From: LoginOK{}, Where: []stream.Expr{
+					&stream.Compare{Op: lexer.EQUALS, X: &stream.Field{Name: "b.User"}, Y: &stream.Field{Name: "a.User"}},
+				}},
+			},
+			Within: time.Minute,
+			Unit:   lexer.MIN,
+		})
+	defer s.Close()
+
+	s.Listen(LoginFail{User: "alice"})
+	s.Listen(LoginFail{User: "bob"})
+	s.Listen(LoginOK{User: "bob"})
+	s.Listen(LoginOK{User: "alice"})
+	s.Listen(LoginOK{User: "alice"})
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT a.User FROM PATTERN [EVERY a=LoginFail -> b=LoginOK(b.User = a.User) WHERE TIMER:WITHIN(1 MIN)]
+	// [bob]
+	// [alice]
+}
+
+func TestPattern(t *testing.T) {
+	now := time.Now()
+	fail := func(user string, sec int) stream.Event {
+		return stream.Event{Time: now.Add(time.Duration(sec) * time.Second), Underlying: LoginFail{User: user}}
+	}
+
+	ok := func(user string, sec int) stream.Event {
+		return stream.Event{Time: now.Add(time.Duration(sec) * time.Second), Underlying: LoginOK{User: user}}
+	}
+
+	cases := []struct {
+		every  bool
+		within time.Duration
+		in     []stream.Event
+		want   []string
+	}{
+		{true, 0, []stream.Event{fail("a", 0), fail("b", 1), ok("c", 2), ok("b", 3)}, []string{"b b"}},
+		{true, 0, []stream.Event{fail("a", 0), fail("a", 1), ok("a", 2)}, []string{"a a", "a a"}},
+		{false, 0, []stream.Event{fail("a", 0), fail("b", 1), ok("b", 2), ok("a", 3)}, []string{"a a"}},
+		{false, 0, []stream.Event{fail("a", 0), ok("a", 1), fail("b", 2), ok("b", 3)}, []string{"a a"}},
+		{true, 10 * time.Second, []stream.Event{fail("a", 0), fail("a", 5), ok("a", 12)}, []string{"a a"}},
+		{false, 10 * time.Second, []stream.Event{fail("a", 0), fail("a", 5), ok("a", 12)}, []string{}},
+	}
+
+	for _, c := range cases {
+		p := &stream.Pattern{
+			Every: c.every,
+			Steps: []stream.PatternStep{
+				{Tag: "a", From: LoginFail{}},
+				{From: LoginOK{}, Where: []stream.Expr{
+					&stream.Compare{Op: lexer.EQUALS, X: &stream.Field{Name: "LoginOK.User"}, Y: &stream.Field{Name: "a.User"}},
+				}},
+			},
+			Within: c.within,
+		}
+
+		got := make([]string, 0)
+		for _, e := range c.in {
+			for _, m := range p.Apply(e) {
+				got = append(got, fmt.Sprintf("%v %v", m["a"].(LoginFail).User, m["LoginOK"].(LoginOK).User))
+			}
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%v: got=%v, want=%v", p, got, c.want)
+		}
+	}
+}
+
+func TestPatternMax(t *testing.T) {
+	p := &stream.Pattern{
+		Every: true,
+		Steps: []stream.PatternStep{
+			{Tag: "a", From: LoginFail{}},
+			{Tag: "b", From: LoginOK{}},
+		},
+		Max: 2,
+	}
+
+	for _, u := range []string{"a", "b", "c"} {
+		p.Apply(stream.NewEvent(LoginFail{User: u}))
+	}
+
+	got := make([]string, 0)
+	for _, m := range p.Apply(stream.NewEvent(LoginOK{User: "d"})) {
+		got = append(got, m["a"].(LoginFail).User)
+	}
+
+	if fmt.Sprint(got) != "[b c]" {
+		t.Errorf("got=%v, want=[b c]", got)
+	}
+}
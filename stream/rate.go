@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+)
+
+// Rate limits the rate of the results of a stream to one output a period, as OUTPUT LAST EVERY 10 SEC does.
+// The period is Events events that entered the results, or the length of time Every,
+// or every result if neither is given. Unit is the unit of time Every is written in as in Time.
+// Kind is what is sent for a period:
+// the events that entered the results in the period for ILLEGAL,
+// those of the first result as soon as it arrives for FIRST,
+// those of the last result for LAST, and the last result as it is for SNAPSHOT.
+// A period of time ends at the first result after it, or at the next Tick,
+// which Run calls every period so that the last period is sent even if the stream goes quiet.
+type Rate struct {
+	Kind   lexer.Token
+	Events int
+	Every  time.Duration
+	Unit   lexer.Token
+	prev   []Event
+	buf    []Event
+	count  int
+	start  time.Time
+	sent   bool
+}
+
+// Apply returns the events to send given the result e at the time now, or an empty slice for none.
+func (r *Rate) Apply(e []Event, now time.Time) []Event {
+	in := inserted(r.prev, e)
+	r.prev = e
+
+	out := make([]Event, 0)
+	if r.Every > 0 {
+		if r.start.IsZero() {
+			r.start = now
+		}
+
+		out = append(out, r.Tick(now)...)
+	}
+
+	switch r.Kind {
+	case lexer.FIRST:
+		if !r.sent && len(in) > 0 {
+			r.sent = true
+			out = append(out, in...)
+		}
+	case lexer.LAST:
+		if len(in) > 0 {
+			r.buf = in
+		}
+	case lexer.SNAPSHOT:
+		r.buf = e
+	default:
+		r.buf = append(r.buf, in...)
+	}
+
+	if r.Every > 0 {
+		return out
+	}
+
+	r.count += len(in)
+	if r.count < r.Events {
+		return out
+	}
+
+	return append(out, r.flush()...)
+}
+
+// Tick returns the events to send for the period of time that has ended by the time now,
+// or an empty slice if it has not ended or no result has arrived yet.
+func (r *Rate) Tick(now time.Time) []Event {
+	out := make([]Event, 0)
+	if r.Every <= 0 || r.start.IsZero() || now.Sub(r.start) < r.Every {
+		return out
+	}
+
+	out = append(out, r.flush()...)
+	for now.Sub(r.start) >= r.Every {
+		r.start = r.start.Add(r.Every)
+	}
+
+	return out
+}
+
+// flush returns the events of the period, and starts the next period.
+func (r *Rate) flush() []Event {
+	out := r.buf
+	r.buf, r.count, r.sent = nil, 0, false
+	return out
+}
+
+func (r *Rate) String() string {
+	return r.node().String()
+}
+
+// node returns the syntax tree of r.
+func (r *Rate) node() *ast.Output {
+	x := &ast.Output{Kind: r.Kind, Events: r.Events}
+	if r.Every > 0 {
+		x.Intervals = ast.Split(r.Every, r.Unit)
+	}
+
+	return x
+}
+
+// inserted returns the events of next that are not in prev, in the order of next.
+// Unlike delta, the events may be in any order, e.g. sorted by ORDER BY.
+func inserted(prev, next []Event) []Event {
+	seen := make(map[uint64]bool, len(prev))
+	for _, e := range prev {
+		seen[e.seq] = true
+	}
+
+	out := make([]Event, 0)
+	for _, e := range next {
+		if !seen[e.seq] {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
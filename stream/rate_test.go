@@ -0,0 +1,117 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/itsubaki/gostream/lexer"
+	"github.com/itsubaki/gostream/stream"
+)
+
+func ExampleRate() {
+	type LogEvent struct {
+		Level   int
+		Message string
+	}
+
+	s := stream.New().
+		SelectAll().
+		From(LogEvent{}).
+		Length(1000).
+		Rate(&stream.Rate{Kind: lexer.LAST, Events: 2})
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		s.Listen(LogEvent{Level: i, Message: "foo"})
+	}
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		fmt.Println((<-s.Output())[0].ResultSet)
+	}
+
+	// Output:
+	// SELECT * FROM LogEvent.LENGTH(1000) OUTPUT LAST EVERY 2 EVENTS
+	// [1 foo]
+	// [3 foo]
+}
+
+func TestRate(t *testing.T) {
+	cases := []struct {
+		kind   lexer.Token
+		events int
+		every  time.Duration
+		want   string
+	}{
+		{lexer.ILLEGAL, 0, 0, "[[1] [2] [3] [4] [5]]"},
+		{lexer.ILLEGAL, 2, 0, "[[1 2] [3 4]]"},
+		{lexer.FIRST, 2, 0, "[[1] [3] [5]]"},
+		{lexer.LAST, 2, 0, "[[2] [4]]"},
+		{lexer.SNAPSHOT, 0, 0, "[[1] [1 2] [1 2 3] [2 3 4] [3 4 5]]"},
+		{lexer.SNAPSHOT, 2, 0, "[[1 2] [2 3 4]]"},
+		{lexer.ILLEGAL, 0, 2 * time.Second, "[[1 2] [3 4]]"},
+		{lexer.FIRST, 0, 2 * time.Second, "[[1] [3] [5]]"},
+		{lexer.LAST, 0, 2 * time.Second, "[[2] [4]]"},
+	}
+
+	for _, c := range cases {
+		r := &stream.Rate{Kind: c.kind, Events: c.events, Every: c.every}
+
+		// the results of LENGTH(3), one event a second
+		events := make([]stream.Event, 0)
+		start := time.Now()
+
+		got := make([][]any, 0)
+		for i := 1; i <= 5; i++ {
+			events = append(events, stream.NewEvent(i))
+			if len(events) > 3 {
+				events = events[1:]
+			}
+
+			out := r.Apply(events, start.Add(time.Duration(i)*time.Second))
+			if len(out) == 0 {
+				continue
+			}
+
+			values := make([]any, len(out))
+			for j := range out {
+				values[j] = out[j].Underlying
+			}
+
+			got = append(got, values)
+		}
+
+		if fmt.Sprint(got) != c.want {
+			t.Errorf("%v: got=%v, want=%v", r, got, c.want)
+		}
+	}
+}
+
+func TestRateIdle(t *testing.T) {
+	type LogEvent struct {
+		Level int
+	}
+
+	s := stream.New().
+		SelectAll().
+		From(LogEvent{}).
+		Length(10).
+		Rate(&stream.Rate{Kind: lexer.LAST, Every: 50 * time.Millisecond, Unit: lexer.MSEC})
+	go s.Run()
+
+	s.Input() <- LogEvent{Level: 1}
+	s.Input() <- LogEvent{Level: 2}
+
+	// no event follows, so the period is sent by the ticker of Run
+	select {
+	case out := <-s.Output():
+		if got := fmt.Sprint(out[len(out)-1].ResultSet); got != "[2]" {
+			t.Errorf("got=%v, want=[2]", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timeout")
+	}
+
+	s.Close()
+}
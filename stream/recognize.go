@@ -0,0 +1,349 @@
+package stream
+
+import (
+	"fmt"
+
+	"github.com/itsubaki/gostream/ast"
+	"github.com/itsubaki/gostream/lexer"
+)
+
+var (
+	_ Expr   = (*Navigate)(nil)
+	_ binder = (*Navigate)(nil)
+	_ binder = (*MatchRecognize)(nil)
+)
+
+// MatchRecognize finds the sequences of events in the window that match Pattern, as MATCH_RECOGNIZE of SQL does.
+// The events are partitioned by the values of PartitionBy, and sorted by OrderBy if it is not nil.
+// Each match is an event whose result set is the values of PartitionBy followed by the values of Measures,
+// and whose underlying value is a map of them by name.
+// Quantified variables are greedy, so a match is found once it cannot be longer, that is,
+// once an event after it does not extend it, or else once any of its events is no longer in the window.
+// For example, the events A B B are a match of A B+ after an event that is not B, not the match A B.
+// The events of a match are not in the matches found later.
+type MatchRecognize struct {
+	PartitionBy []*Field
+	OrderBy     *OrderBy
+	Measures    []Measure
+	Pattern     []Var
+	Define      []Define
+	matched     map[uint64]bool
+	pending     []pending
+}
+
+// pending is a match that could be longer with the events to come, and the sequence numbers of its events.
+type pending struct {
+	event Event
+	seq   []uint64
+}
+
+// Measure is a value of a match named Name such as LAST(C.Price) AS high.
+type Measure struct {
+	Name string
+	Expr Expr
+}
+
+// Var is a variable of the pattern of a MatchRecognize such as B+ or B{2,},
+// which matches from Min to Max consecutive events, or any number of events from Min if Max is -1, as many as possible.
+type Var struct {
+	Name string
+	Min  int
+	Max  int
+}
+
+// Define is the condition of the events of the variable Name such as B AS B.Price > PREV(B.Price).
+// A variable without a condition matches any event.
+type Define struct {
+	Name string
+	Cond Expr
+}
+
+// Navigate is PREV, FIRST or LAST of an expression in the conditions and the measures of a MatchRecognize
+// such as PREV(B.Price), which is evaluated on the event before, the first or the last event of each variable.
+type Navigate struct {
+	Func lexer.Token
+	X    Expr
+}
+
+// navigation are the keys of the events for PREV and FIRST in the events of the variables of a match.
+// They are not identifiers, so that they are not the names of variables.
+var navigation = map[lexer.Token]string{
+	lexer.PREV:  "(PREV)",
+	lexer.FIRST: "(FIRST)",
+}
+
+func (x *Navigate) Eval(input any) any {
+	m, ok := input.(Match)
+	if !ok {
+		return nil
+	}
+
+	if k, ok := navigation[x.Func]; ok {
+		m, _ = m[k].(Match)
+	}
+
+	return x.X.Eval(m)
+}
+
+func (x *Navigate) bind(r resolver) {
+	if b, ok := x.X.(binder); ok {
+		b.bind(r)
+	}
+}
+
+func (x *Navigate) String() string {
+	return node(x).String()
+}
+
+func (x *MatchRecognize) Apply(e []Event) []Event {
+	if x.matched == nil {
+		x.matched = make(map[uint64]bool)
+	}
+
+	// the events of the matches that are no longer in the window
+	window := make(map[uint64]bool)
+	for _, ev := range e {
+		window[ev.seq] = true
+	}
+
+	// the matches that could be longer, but whose events are no longer in the window
+	out := make([]Event, 0)
+	for _, p := range x.pending {
+		if all(p.seq, window) {
+			continue
+		}
+
+		out = append(out, p.event)
+		for _, seq := range p.seq {
+			x.matched[seq] = true
+		}
+	}
+
+	for seq := range x.matched {
+		if !window[seq] {
+			delete(x.matched, seq)
+		}
+	}
+
+	x.pending = x.pending[:0]
+	for _, rows := range x.partition(e) {
+		// after the last event of the matches found before
+		var start int
+		for i := range rows {
+			if x.matched[rows[i].seq] {
+				start = i + 1
+			}
+		}
+
+		for start < len(rows) {
+			vars, ok, open := x.match(rows, start, 0, nil)
+			if open {
+				// wait for the events to come
+				if ok && len(vars) > 0 {
+					x.pending = append(x.pending, pending{event: x.measure(rows, start, vars), seq: seqs(rows[start : start+len(vars)])})
+				}
+
+				break
+			}
+
+			if !ok || len(vars) == 0 {
+				start++
+				continue
+			}
+
+			out = append(out, x.measure(rows, start, vars))
+			for _, r := range rows[start : start+len(vars)] {
+				x.matched[r.seq] = true
+			}
+
+			start += len(vars)
+		}
+	}
+
+	return out
+}
+
+// partition returns the events of each partition in the order the partitions appear,
+// sorted by OrderBy if it is not nil.
+func (x *MatchRecognize) partition(e []Event) [][]Event {
+	index, out := make(map[string]int), make([][]Event, 0)
+	for _, ev := range e {
+		values := make([]any, len(x.PartitionBy))
+		for i, p := range x.PartitionBy {
+			values[i] = normalize(p.Eval(ev.Underlying))
+		}
+
+		key := fmt.Sprintf("%#v", values)
+		if _, ok := index[key]; !ok {
+			index[key] = len(out)
+			out = append(out, make([]Event, 0))
+		}
+
+		out[index[key]] = append(out[index[key]], ev)
+	}
+
+	if x.OrderBy != nil {
+		for i := range out {
+			out[i] = x.OrderBy.Apply(out[i])
+		}
+	}
+
+	return out
+}
+
+// match returns the variables of the events of rows from start that match the pattern from the k-th variable,
+// given the variables of the events so far. Quantified variables take as many events as possible.
+// It also reports whether the match is open, that is, the events after rows could make a longer match,
+// or a match if there is none, because a variable took the events up to the last one of rows.
+func (x *MatchRecognize) match(rows []Event, start, k int, vars []string) ([]string, bool, bool) {
+	if k == len(x.Pattern) {
+		return vars, true, false
+	}
+
+	v, n, open := x.Pattern[k], 0, false
+	taken := vars
+	for v.Max < 0 || n < v.Max {
+		if start+len(taken) >= len(rows) {
+			open = true
+			break
+		}
+
+		if !x.define(rows, start, taken, v.Name) {
+			break
+		}
+
+		taken, n = append(taken[:len(taken):len(taken)], v.Name), n+1
+	}
+
+	for ; n >= v.Min; n-- {
+		out, ok, o := x.match(rows, start, k+1, taken[:len(vars)+n])
+		if open = open || o; ok {
+			return out, true, open
+		}
+	}
+
+	return nil, false, open
+}
+
+// seqs returns the sequence numbers of e.
+func seqs(e []Event) []uint64 {
+	out := make([]uint64, len(e))
+	for i := range e {
+		out[i] = e[i].seq
+	}
+
+	return out
+}
+
+// all reports whether all of seq are in the window.
+func all(seq []uint64, window map[uint64]bool) bool {
+	for _, s := range seq {
+		if !window[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// define reports whether the event after the events of vars is an event of the variable name.
+func (x *MatchRecognize) define(rows []Event, start int, vars []string, name string) bool {
+	for _, d := range x.Define {
+		if d.Name != name {
+			continue
+		}
+
+		v, ok := d.Cond.Eval(context(rows, start, append(vars[:len(vars):len(vars)], name))).(bool)
+		return ok && v
+	}
+
+	return true
+}
+
+// measure returns the event of the match of the events of rows from start.
+func (x *MatchRecognize) measure(rows []Event, start int, vars []string) Event {
+	last := rows[start+len(vars)-1]
+	values := make(map[string]any)
+	out := make([]any, 0, len(x.PartitionBy)+len(x.Measures))
+	for _, p := range x.PartitionBy {
+		v := p.Eval(last.Underlying)
+		values[p.Name], out = v, append(out, v)
+	}
+
+	c := context(rows, start, vars)
+	for _, m := range x.Measures {
+		v := m.Expr.Eval(c)
+		values[m.Name], out = v, append(out, v)
+	}
+
+	ev := NewEvent(values)
+	ev.Time, ev.ResultSet = last.Time, out
+	return ev
+}
+
+// context returns the last event of each variable of the events of rows from start by its name,
+// with the first events by FIRST and the events before them by PREV.
+func context(rows []Event, start int, vars []string) Match {
+	last, first, prev := make(Match), make(Match), make(Match)
+	for i, v := range vars {
+		r := start + i
+		if _, ok := first[v]; !ok {
+			first[v] = rows[r].Underlying
+		}
+
+		last[v], prev[v] = rows[r].Underlying, nil
+		if r > 0 {
+			prev[v] = rows[r-1].Underlying
+		}
+	}
+
+	last[navigation[lexer.FIRST]] = first
+	last[navigation[lexer.PREV]] = prev
+	return last
+}
+
+func (x *MatchRecognize) bind(r resolver) {
+	for _, p := range x.PartitionBy {
+		p.bind(r)
+	}
+
+	if x.OrderBy != nil {
+		x.OrderBy.bind(r)
+	}
+}
+
+func (x *MatchRecognize) String() string {
+	return x.node().String()
+}
+
+// node returns the syntax tree of x.
+func (x *MatchRecognize) node() *ast.MatchRecognize {
+	out := &ast.MatchRecognize{
+		PartitionBy: make([]ast.Expr, len(x.PartitionBy)),
+		Measures:    make([]*ast.Alias, len(x.Measures)),
+		Pattern:     make([]ast.Var, len(x.Pattern)),
+		Define:      make([]ast.Define, len(x.Define)),
+	}
+
+	for i, p := range x.PartitionBy {
+		out.PartitionBy[i] = node(p)
+	}
+
+	if x.OrderBy != nil {
+		out.OrderBy = &ast.OrderBy{Expr: ast.Field(x.OrderBy.Name), Desc: x.OrderBy.Desc}
+	}
+
+	for i, m := range x.Measures {
+		out.Measures[i] = &ast.Alias{Expr: node(m.Expr), Name: m.Name}
+	}
+
+	for i, v := range x.Pattern {
+		out.Pattern[i] = ast.Var{Name: v.Name, Min: v.Min, Max: v.Max}
+	}
+
+	for i, d := range x.Define {
+		out.Define[i] = ast.Define{Name: d.Name, Cond: node(d.Cond)}
+	}
+
+	return out
+}
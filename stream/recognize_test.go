@@ -0,0 +1,136 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/itsubaki/gostream/lexer"
+	"github.com/itsubaki/gostream/stream"
+)
+
+type Ticker struct {
+	Symbol string
+	Price  float64
+}
+
+// rising is B.Price > PREV(B.Price) for the variable B.
+func rising(name string) stream.Define {
+	price := &stream.Field{Name: name + ".Price"}
+	return stream.Define{
+		Name: name,
+		Cond: &stream.Compare{Op: lexer.LARGER, X: price, Y: &stream.Navigate{Func: lexer.PREV, X: price}},
+	}
+}
+
+func ExampleMatchRecognize() {
+	s := stream.New().
+		SelectAll().
+		From(Ticker{}).
+		Length(10).
+		MatchRecognize(&stream.MatchRecognize{
+			PartitionBy: []*stream.Field{{Name: "Symbol"}},
+			Measures: []stream.Measure{
+				{Name: "low", Expr: &stream.Field{Name: "A.Price"}},
+				{Name: "high", Expr: &stream.Navigate{Func: lexer.LAST, X: &stream.Field{Name: "C.Price"}}},
+			},
+			Pattern: []stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 1, Max: -1}, {Name: "C", Min: 1, Max: 1}},
+			Define:  []stream.Define{rising("B"), rising("C")},
+		})
+	defer s.Close()
+
+	for _, t := range []Ticker{{"GOOG", 10}, {"AAPL", 5}, {"GOOG", 11}, {"AAPL", 4}, {"GOOG", 12}, {"GOOG", 13}, {"GOOG", 12}} {
+		s.Listen(t)
+	}
+
+	fmt.Println(s)
+	for len(s.Output()) > 0 {
+		out := <-s.Output()
+		fmt.Println(out[len(out)-1].ResultSet)
+	}
+
+	// Output:
+	// SELECT * FROM Ticker.LENGTH(10) MATCH_RECOGNIZE (PARTITION BY Symbol MEASURES A.Price AS low, LAST(C.Price) AS high PATTERN (A B+ C) DEFINE B AS B.Price > PREV(B.Price), C AS C.Price > PREV(C.Price))
+	// [GOOG 10 13]
+}
+
+func TestMatchRecognize(t *testing.T) {
+	prices := func(p ...float64) []stream.Event {
+		out := make([]stream.Event, len(p))
+		for i := range p {
+			out[i] = stream.NewEvent(Ticker{Symbol: "GOOG", Price: p[i]})
+		}
+
+		return out
+	}
+
+	cases := []struct {
+		pattern []stream.Var
+		in      []stream.Event
+		want    string
+	}{
+		{[]stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 1, Max: -1}}, prices(1, 2, 3, 1, 5, 4), "[[1 3] [1 5]]"},
+		{[]stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 2, Max: 2}}, prices(1, 2, 1, 2, 3, 4), "[[1 3]]"},
+		{[]stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 1, Max: -1}}, prices(1, 2, 3, 4), "[]"},
+		{[]stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 0, Max: -1}, {Name: "C", Min: 1, Max: 1}}, prices(3, 2, 1, 2, 0), "[[3 <nil>] [1 2]]"},
+		{[]stream.Var{{Name: "B", Min: 1, Max: -1}}, prices(1, 2, 3, 1), "[[<nil> 3]]"},
+		{[]stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 0, Max: 1}, {Name: "B", Min: 1, Max: 1}}, prices(1, 2, 0), "[[1 2]]"},
+	}
+
+	for _, c := range cases {
+		x := &stream.MatchRecognize{
+			Measures: []stream.Measure{
+				{Name: "first", Expr: &stream.Navigate{Func: lexer.FIRST, X: &stream.Field{Name: "A.Price"}}},
+				{Name: "last", Expr: &stream.Field{Name: "B.Price"}},
+			},
+			Pattern: c.pattern,
+			Define:  []stream.Define{rising("B")},
+		}
+
+		got := make([][]any, 0)
+		for i := range c.in {
+			for _, e := range x.Apply(c.in[:i+1]) {
+				got = append(got, e.ResultSet)
+			}
+		}
+
+		if fmt.Sprint(got) != c.want {
+			t.Errorf("%v: got=%v, want=%v", x, got, c.want)
+		}
+	}
+}
+
+func TestMatchRecognizeWindow(t *testing.T) {
+	e := make([]stream.Event, 0)
+	for _, p := range []float64{1, 2, 3, 4} {
+		e = append(e, stream.NewEvent(Ticker{Symbol: "GOOG", Price: p}))
+	}
+
+	x := &stream.MatchRecognize{
+		Measures: []stream.Measure{
+			{Name: "first", Expr: &stream.Field{Name: "A.Price"}},
+			{Name: "last", Expr: &stream.Navigate{Func: lexer.LAST, X: &stream.Field{Name: "B.Price"}}},
+		},
+		Pattern: []stream.Var{{Name: "A", Min: 1, Max: 1}, {Name: "B", Min: 1, Max: -1}},
+		Define:  []stream.Define{rising("B")},
+	}
+
+	// A B B could be longer
+	if got := x.Apply(e[:3]); len(got) != 0 {
+		t.Errorf("got=%v", got)
+	}
+
+	// A is no longer in the window
+	got := make([][]any, 0)
+	for _, ev := range x.Apply(e[1:]) {
+		got = append(got, ev.ResultSet)
+	}
+
+	if fmt.Sprint(got) != "[[1 3]]" {
+		t.Errorf("got=%v", got)
+	}
+
+	// B B are not in the matches found later
+	if got := x.Apply(e[2:]); len(got) != 0 {
+		t.Errorf("got=%v", got)
+	}
+}
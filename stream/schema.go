@@ -0,0 +1,48 @@
+package stream
+
+import "reflect"
+
+// TypeKey is the key of the name of the event type in the events of a Schema,
+// e.g. {"type": "LogEvent", "Level": 1}, unless the schema has its own Key.
+const TypeKey = "type"
+
+// Schema is an event type declared without a Go struct.
+// Events of the type are map[string]any, e.g. decoded JSON,
+// whose value of Key, or TypeKey if Key is empty, is Name.
+// Fields are the types of their fields by name.
+type Schema struct {
+	Name   string
+	Key    string
+	Fields map[string]reflect.Type
+}
+
+// NewSchema returns a schema of the named event type.
+// The type of each field is given by an example value, e.g. NewSchema("LogEvent", map[string]any{"Level": 0}).
+func NewSchema(name string, fields map[string]any) Schema {
+	s := Schema{
+		Name:   name,
+		Fields: make(map[string]reflect.Type),
+	}
+
+	for k, v := range fields {
+		s.Fields[k] = reflect.TypeOf(v)
+	}
+
+	return s
+}
+
+// Match reports whether input is an event of the schema.
+func (s Schema) Match(input any) bool {
+	m, ok := input.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	key := s.Key
+	if key == "" {
+		key = TypeKey
+	}
+
+	name, ok := m[key].(string)
+	return ok && name == s.Name
+}
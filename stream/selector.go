@@ -2,12 +2,18 @@ package stream
 
 import (
 	"reflect"
-	"strings"
+	"sort"
 )
 
 var (
 	_ Selector = (*SelectAll)(nil)
 	_ Selector = (*Select)(nil)
+	_ Selector = (*SelectExpr)(nil)
+)
+
+var (
+	_ binder = (*Select)(nil)
+	_ binder = (*SelectExpr)(nil)
 )
 
 type Selector interface {
@@ -15,13 +21,33 @@ type Selector interface {
 	String() string
 }
 
+// SelectAll selects the exported fields of struct events in order, or the values of map events by key.
 type SelectAll struct{}
 
 func (s SelectAll) Apply(e []Event) []Event {
+	if m, ok := e[len(e)-1].Underlying.(map[string]any); ok {
+		// schema-less events have no field order
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, m[k])
+		}
+
+		return e
+	}
+
 	v := reflect.ValueOf(e[len(e)-1].Underlying)
 	t := v.Type()
 
 	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+
 		e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, v.Field(i).Interface())
 	}
 
@@ -33,22 +59,42 @@ func (s SelectAll) String() string {
 }
 
 type Select struct {
-	Name string
+	Name  string
+	Index []int
 }
 
 func (s Select) Apply(e []Event) []Event {
-	v := reflect.ValueOf(e[len(e)-1].Underlying)
-	t := v.Type()
-
-	for i := 0; i < t.NumField(); i++ {
-		if t.Field(i).Name == strings.Trim(s.Name, "`") {
-			e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, v.Field(i).Interface())
-		}
+	if v, ok := field(e[len(e)-1].Underlying, s.Name, s.Index); ok {
+		e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, v)
 	}
 
 	return e
 }
 
+func (s *Select) bind(r resolver) {
+	s.Index = r(s.Name)
+}
+
 func (s Select) String() string {
 	return s.Name
 }
+
+// SelectExpr selects the value of an expression such as UPPER(Message).
+type SelectExpr struct {
+	Expr Expr
+}
+
+func (s SelectExpr) Apply(e []Event) []Event {
+	e[len(e)-1].ResultSet = append(e[len(e)-1].ResultSet, s.Expr.Eval(e[len(e)-1].Underlying))
+	return e
+}
+
+func (s *SelectExpr) bind(r resolver) {
+	if b, ok := s.Expr.(binder); ok {
+		b.bind(r)
+	}
+}
+
+func (s SelectExpr) String() string {
+	return s.Expr.String()
+}
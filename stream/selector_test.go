@@ -1 +1,79 @@
 package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/itsubaki/gostream/stream"
+)
+
+func ExampleSelect() {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	s := stream.New().
+		Select("Message").
+		Select("Level").
+		From(LogEvent{}).
+		Length(10)
+	defer s.Close()
+
+	s.Listen(LogEvent{Level: 1, Message: "foo"})
+	out := <-s.Output()
+
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [foo 1]
+}
+
+func ExampleSelectAll() {
+	type LogEvent struct {
+		Level   int
+		Message string
+		secret  string
+	}
+
+	s := stream.New().
+		SelectAll().
+		From(LogEvent{}).
+		Length(10)
+	defer s.Close()
+
+	s.Listen(LogEvent{Level: 1, Message: "foo", secret: "bar"})
+	out := <-s.Output()
+
+	fmt.Println(out[len(out)-1].ResultSet)
+
+	// Output:
+	// [1 foo]
+}
+
+func BenchmarkSelect(b *testing.B) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	cases := []struct {
+		name string
+		in   stream.Selector
+	}{
+		{"FieldByName", &stream.Select{Name: "Message"}},
+		{"FieldByIndex", &stream.Select{Name: "Message", Index: []int{2}}},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				e := []stream.Event{stream.NewEvent(LogEvent{Message: "foo"})}
+				c.in.Apply(e)
+			}
+		})
+	}
+}
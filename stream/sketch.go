@@ -0,0 +1,264 @@
+package stream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+const (
+	// hllPrecision is the number of bits of the hash used to select a register of hyperloglog.
+	// 2^14 registers give a standard error of 1.04/sqrt(2^14), about 0.81%.
+	hllPrecision = 14
+
+	// cmWidth and cmDepth are the dimensions of countmin.
+	// A count is overestimated by at most e/cmWidth (about 0.13%) of the events in the window
+	// with probability 1-e^-cmDepth (about 99.3%). It is never underestimated.
+	cmWidth = 2048
+	cmDepth = 5
+
+	// topkCandidates is the number of candidates of TopK tracked for each of its K values.
+	topkCandidates = 4
+)
+
+// ddsketch is a quantile sketch with relative accuracy (DDSketch).
+// Values are counted in logarithmically sized buckets, so any quantile is
+// estimated within a relative error of alpha, and the number of buckets
+// depends only on the range of the values, not on how many there are.
+// Unlike t-digest, a bucket count can be decremented,
+// so values expired from a sliding window can be removed from the sketch.
+type ddsketch struct {
+	gamma    float64
+	positive map[int]int
+	negative map[int]int
+	zero     int
+	count    int
+}
+
+func newDDSketch(alpha float64) *ddsketch {
+	return &ddsketch{
+		gamma:    (1 + alpha) / (1 - alpha),
+		positive: make(map[int]int),
+		negative: make(map[int]int),
+	}
+}
+
+func (s *ddsketch) add(v float64, n int) {
+	s.count += n
+
+	switch {
+	case v > 0:
+		update(s.positive, s.key(v), n)
+	case v < 0:
+		update(s.negative, s.key(-v), n)
+	default:
+		s.zero += n
+	}
+}
+
+func (s *ddsketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := clamp(q) * float64(s.count-1)
+
+	var n float64
+	neg := keys(s.negative)
+	for i := len(neg) - 1; i >= 0; i-- {
+		n += float64(s.negative[neg[i]])
+		if n > rank {
+			return -s.value(neg[i])
+		}
+	}
+
+	n += float64(s.zero)
+	if n > rank {
+		return 0
+	}
+
+	pos := keys(s.positive)
+	for _, k := range pos {
+		n += float64(s.positive[k])
+		if n > rank {
+			return s.value(k)
+		}
+	}
+
+	return s.value(pos[len(pos)-1])
+}
+
+func (s *ddsketch) key(v float64) int {
+	return int(math.Ceil(math.Log(v) / math.Log(s.gamma)))
+}
+
+func (s *ddsketch) value(k int) float64 {
+	return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+}
+
+func update(m map[int]int, k, n int) {
+	m[k] += n
+	if m[k] <= 0 {
+		delete(m, k)
+	}
+}
+
+func keys(m map[int]int) []int {
+	out := make([]int, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+
+	sort.Ints(out)
+	return out
+}
+
+// hyperloglog is a distinct count sketch (HyperLogLog) over a sliding window.
+// Each register keeps, instead of a single maximum, the monotonic list of
+// maxima of the events that are still to expire, so an expired event can be removed.
+// The expected length of each list is logarithmic in the number of events in the window.
+type hyperloglog struct {
+	seed      maphash.Seed
+	registers []monotonic
+	sum       float64
+	zeros     int
+}
+
+func newHyperLogLog() *hyperloglog {
+	return &hyperloglog{
+		seed:      maphash.MakeSeed(),
+		registers: make([]monotonic, 1<<hllPrecision),
+		sum:       1 << hllPrecision,
+		zeros:     1 << hllPrecision,
+	}
+}
+
+func (h *hyperloglog) add(seq uint64, v any) {
+	x := hash(h.seed, v)
+	rho := bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1
+
+	h.update(x>>(64-hllPrecision), func(r *monotonic) {
+		r.push(sample{seq: seq, key: float64(rho)}, lesseq)
+	})
+}
+
+func (h *hyperloglog) remove(seq uint64, v any) {
+	h.update(hash(h.seed, v)>>(64-hllPrecision), func(r *monotonic) {
+		r.pop(seq)
+	})
+}
+
+// update applies f to the j-th register, keeping the harmonic sum of the registers up to date.
+func (h *hyperloglog) update(j uint64, f func(r *monotonic)) {
+	r := &h.registers[j]
+
+	before, _ := r.front().key.(float64)
+	f(r)
+	after, _ := r.front().key.(float64)
+
+	h.sum += math.Pow(2, -after) - math.Pow(2, -before)
+	if before == 0 {
+		h.zeros--
+	}
+
+	if after == 0 {
+		h.zeros++
+	}
+}
+
+func (h *hyperloglog) count() int {
+	m := float64(len(h.registers))
+	e := 0.7213 / (1 + 1.079/m) * m * m / h.sum
+	if e <= 2.5*m && h.zeros > 0 {
+		// small range correction
+		e = m * math.Log(m/float64(h.zeros))
+	}
+
+	return int(math.Round(e))
+}
+
+// countmin is a frequency sketch (Count-Min Sketch).
+// Counts can be decremented, so events expired from the window can be removed.
+type countmin struct {
+	seed  maphash.Seed
+	table [cmDepth][cmWidth]int
+}
+
+func newCountMin() *countmin {
+	return &countmin{
+		seed: maphash.MakeSeed(),
+	}
+}
+
+func (c *countmin) add(v any, n int) {
+	h := hash(c.seed, v)
+	for i := range c.table {
+		c.table[i][c.column(h, i)] += n
+	}
+}
+
+func (c *countmin) estimate(v any) int {
+	h := hash(c.seed, v)
+
+	est := math.MaxInt
+	for i := range c.table {
+		est = min(est, c.table[i][c.column(h, i)])
+	}
+
+	return est
+}
+
+// column derives the i-th hash from the two halves of h.
+func (c *countmin) column(h uint64, i int) int {
+	h1, h2 := h&math.MaxUint32, h>>32
+	return int((h1 + uint64(i)*h2) % cmWidth)
+}
+
+// hash returns the hash of v by its type and value, so that 1 and "1" are different values.
+// Values are hashed by their key, so numbers of the same value such as 1 and 1.0 are the same value.
+func hash(seed maphash.Seed, v any) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+
+	var b [8]byte
+	switch v := key(v).(type) {
+	case nil:
+		h.WriteByte('n')
+	case int64:
+		h.WriteByte('i')
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		h.Write(b[:])
+	case uint64:
+		h.WriteByte('u')
+		binary.LittleEndian.PutUint64(b[:], v)
+		h.Write(b[:])
+	case float64:
+		h.WriteByte('f')
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		h.Write(b[:])
+	case bool:
+		h.WriteByte('b')
+		if v {
+			h.WriteByte(1)
+		}
+	case string:
+		h.WriteByte('s')
+		h.WriteString(v)
+	case time.Time:
+		h.WriteByte('t')
+		binary.LittleEndian.PutUint64(b[:], uint64(v.UnixNano()))
+		h.Write(b[:])
+	case unhashable:
+		h.WriteByte('?')
+		h.WriteString(string(v))
+	default:
+		h.WriteByte('?')
+		h.WriteString(fmt.Sprintf("%T %#v", v, v))
+	}
+
+	return h.Sum64()
+}
@@ -2,7 +2,6 @@ package stream
 
 import (
 	"fmt"
-	"reflect"
 	"sort"
 	"strings"
 )
@@ -17,6 +16,8 @@ var (
 	_ Sorter = (*OrderBy)(nil)
 )
 
+var _ binder = (*OrderBy)(nil)
+
 type Sorter interface {
 	Apply(e []Event) []Event
 	String() string
@@ -32,35 +33,29 @@ func (o *NoOrder) String() string {
 	return ""
 }
 
+// OrderBy sorts the events by the field Name, a dotted path resolved to the index path of a struct field
+// when the stream is bound. Index is the index of the field in the struct of events if Name is empty.
 type OrderBy struct {
 	Name  string
 	Index int
 	Desc  bool
+	path  []int
 }
 
 func (o *OrderBy) Apply(e []Event) []Event {
 	out := append(make([]Event, 0), e...)
 
+	index := o.path
+	if index == nil && o.Name == "" {
+		index = []int{o.Index}
+	}
+
 	sort.Slice(out, func(i, j int) bool {
-		vi := reflect.ValueOf(out[i].Underlying).Field(o.Index).Interface()
-		vj := reflect.ValueOf(out[j].Underlying).Field(o.Index).Interface()
-
-		switch v := vi.(type) {
-		case int:
-			return v < vj.(int)
-		case int32:
-			return v < vj.(int32)
-		case int64:
-			return v < vj.(int64)
-		case float32:
-			return v < vj.(float32)
-		case float64:
-			return v < vj.(float64)
-		case string:
-			return v < vj.(string)
-		}
+		vi, _ := field(out[i].Underlying, o.Name, index)
+		vj, _ := field(out[j].Underlying, o.Name, index)
 
-		return false
+		c, ok := compare(vi, vj)
+		return ok && c < 0
 	})
 
 	if o.Desc {
@@ -72,6 +67,10 @@ func (o *OrderBy) Apply(e []Event) []Event {
 	return out
 }
 
+func (o *OrderBy) bind(r resolver) {
+	o.path = r(o.Name)
+}
+
 func (o *OrderBy) String() string {
 	var buf strings.Builder
 
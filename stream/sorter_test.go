@@ -59,3 +59,58 @@ func ExampleOrderBy_desc() {
 	// Output:
 	// {9}{8}{7}{6}{5}{4}{3}{2}{1}{0}
 }
+
+func ExampleOrderBy_pointer() {
+	type Level uint16
+	type LogEvent struct {
+		Level *Level
+	}
+
+	e := make([]stream.Event, 0)
+	for _, i := range []Level{3, 1, 2} {
+		l := i
+		e = append(e, stream.NewEvent(LogEvent{
+			Level: &l,
+		}))
+	}
+
+	o := &stream.OrderBy{
+		Name:  "Level",
+		Index: 0,
+	}
+
+	out := o.Apply(e)
+	for _, ev := range out {
+		fmt.Print(*ev.Underlying.(LogEvent).Level)
+	}
+
+	// Output:
+	// 123
+}
+
+func ExampleOrderBy_index() {
+	type LogEvent struct {
+		Message string
+		Level   int
+	}
+
+	e := make([]stream.Event, 0)
+	for _, i := range []int{3, 1, 2} {
+		e = append(e, stream.NewEvent(LogEvent{
+			Message: "foo",
+			Level:   i,
+		}))
+	}
+
+	o := &stream.OrderBy{
+		Index: 1,
+	}
+
+	out := o.Apply(e)
+	for _, ev := range out {
+		fmt.Print(ev.Underlying.(LogEvent).Level)
+	}
+
+	// Output:
+	// 123
+}
@@ -4,24 +4,32 @@ import (
 	"fmt"
 	"log"
 	"reflect"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/itsubaki/gostream/ast"
 	"github.com/itsubaki/gostream/lexer"
 )
 
 type Stream struct {
 	in         chan any
 	out        chan []Event
+	deltas     chan Delta
+	istream    lexer.Token
 	events     []Event
 	selector   []Selector
 	aggregator []Aggeregator
 	window     Window
+	recognize  *MatchRecognize
 	where      []Where
 	orderby    Sorter
 	limit      Limiter
+	rate       *Rate
 	from       any
+	pattern    *Pattern
+	naming     Naming
+	query      *ast.Query
+	fields     map[binder][]FieldIndex
 	closed     bool
 	mutex      sync.RWMutex
 }
@@ -30,11 +38,14 @@ func New() *Stream {
 	return &Stream{
 		in:       make(chan any, 1024),
 		out:      make(chan []Event, 1024),
+		deltas:   make(chan Delta, 1024),
 		events:   make([]Event, 0),
 		selector: make([]Selector, 0),
 		where:    make([]Where, 0),
 		orderby:  &NoOrder{},
 		limit:    &NoLimit{},
+		query:    &ast.Query{Fields: make([]ast.Expr, 0)},
+		fields:   make(map[binder][]FieldIndex),
 		mutex:    sync.RWMutex{},
 	}
 }
@@ -47,21 +58,52 @@ func (s *Stream) Output() chan []Event {
 	return s.out
 }
 
+// Deltas returns the channel of the events inserted into and expired from the window
+// of a stream of ISTREAM, RSTREAM or IRSTREAM, which sends nothing to Output.
+func (s *Stream) Deltas() chan Delta {
+	return s.deltas
+}
+
 func (s *Stream) Listen(input any) {
 	if s.IsClosed() {
 		return
 	}
 
+	if s.pattern == nil {
+		s.emit(input)
+		return
+	}
+
+	for _, m := range s.pattern.Apply(NewEvent(input)) {
+		s.emit(m)
+	}
+}
+
+// emit updates the stream with the event input, and sends the events in the window to the output,
+// or the events inserted into and expired from the window to the deltas.
+func (s *Stream) emit(input any) {
+	prev := s.events
 	s.Update(input)
+	if s.istream != lexer.ILLEGAL {
+		s.delta(prev)
+		return
+	}
 
 	// aggregate function
 	out := append(make([]Event, 0), s.events...)
-	for _, a := range s.aggregator {
-		out = a.Apply(out)
+	if s.recognize != nil {
+		out = s.recognize.Apply(out)
 	}
+	out = s.aggregate(out)
 
 	// order by limit offset
 	out = s.limit.Apply(s.orderby.Apply(out))
+
+	// output rate
+	if s.rate != nil {
+		out = s.rate.Apply(out, time.Now())
+	}
+
 	if len(out) == 0 {
 		return
 	}
@@ -69,6 +111,60 @@ func (s *Stream) Listen(input any) {
 	s.Output() <- out
 }
 
+// delta sends the events inserted into and expired from the window since its contents prev.
+// The results of aggregate functions are in the result set of the last inserted event as in the window.
+func (s *Stream) delta(prev []Event) {
+	in, out := delta(prev, s.events)
+
+	d := Delta{New: make([]Event, 0), Old: make([]Event, 0)}
+	if s.istream != lexer.RSTREAM {
+		d.New = append(d.New, in...)
+	}
+
+	if s.istream != lexer.ISTREAM {
+		d.Old = append(d.Old, out...)
+	}
+
+	// aggregate function
+	d.New = s.aggregate(d.New)
+
+	// order by limit offset
+	d.New = s.limit.Apply(s.orderby.Apply(d.New))
+	d.Old = s.limit.Apply(s.orderby.Apply(d.Old))
+	if len(d.New) == 0 && len(d.Old) == 0 {
+		return
+	}
+
+	s.Deltas() <- d
+}
+
+// aggregate appends the results of the aggregate functions to the last event of out.
+// The accumulators keep the results over the window, but once an aggregate function such as DISTINCT
+// has filtered the events, the ones after it are computed over the events it returns.
+func (s *Stream) aggregate(out []Event) []Event {
+	var filtered bool
+	for _, a := range s.aggregator {
+		acc, ok := a.(Accumulator)
+		if !ok {
+			out, filtered = a.Apply(out), true
+			continue
+		}
+
+		if len(out) == 0 {
+			continue
+		}
+
+		if filtered {
+			out = acc.Apply(out)
+			continue
+		}
+
+		out[len(out)-1].ResultSet = append(out[len(out)-1].ResultSet, acc.Result())
+	}
+
+	return out
+}
+
 func (s *Stream) Update(input any) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -85,9 +181,30 @@ func (s *Stream) Update(input any) {
 		return
 	}
 
-	// window
+	// window, or the event alone if there is no window
+	prev := s.events
 	buf := append(s.events, NewEvent(input))
-	s.events = s.window.Apply(buf)
+	s.events = buf[len(buf)-1:]
+	if s.window != nil {
+		s.events = s.window.Apply(buf)
+	}
+
+	// inserted/expired events
+	in, out := delta(prev, s.events)
+	for _, a := range s.aggregator {
+		acc, ok := a.(Accumulator)
+		if !ok {
+			continue
+		}
+
+		for _, e := range out {
+			acc.Remove(e)
+		}
+
+		for _, e := range in {
+			acc.Add(e)
+		}
+	}
 
 	// select
 	for _, sl := range s.selector {
@@ -99,12 +216,49 @@ func (s *Stream) IsClosed() bool {
 	return s.closed
 }
 
+// Run listens to the events sent to Input until the stream is closed.
+// For an output rate of a period of time, it also sends the results of a period
+// that has ended when no event arrives, e.g. the last period before the stream goes quiet.
 func (s *Stream) Run() {
-	for input := range s.in {
-		s.Listen(input)
+	if s.rate == nil || s.rate.Every <= 0 {
+		for input := range s.in {
+			s.Listen(input)
+		}
+
+		return
+	}
+
+	ticker := time.NewTicker(s.rate.Every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case input, ok := <-s.in:
+			if !ok {
+				return
+			}
+
+			s.Listen(input)
+		case now := <-ticker.C:
+			s.tick(now)
+		}
 	}
 }
 
+// tick sends the results of the period of the output rate that has ended by the time now, if any.
+func (s *Stream) tick(now time.Time) {
+	if s.IsClosed() {
+		return
+	}
+
+	out := s.rate.Tick(now)
+	if len(out) == 0 {
+		return
+	}
+
+	s.Output() <- out
+}
+
 func (s *Stream) Close() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -116,6 +270,7 @@ func (s *Stream) Close() error {
 
 	close(s.Input())
 	close(s.Output())
+	close(s.Deltas())
 
 	return nil
 }
@@ -123,21 +278,108 @@ func (s *Stream) Close() error {
 func (s *Stream) From(typ any) *Stream {
 	s.from = typ
 	s.where = append(s.where, From{Type: typ})
+	s.query.From = From{Type: typ}.String()
+
+	// select and aggregate functions may be added before from
+	s.rebind()
 	return s
 }
 
+// Pattern sets the pattern whose matches are the events of the stream instead of the events of a type.
+// The fields of a Match are selected by a dotted path such as a.User.
+func (s *Stream) Pattern(p *Pattern) *Stream {
+	s.pattern = p
+	s.query.Pattern = p.node()
+	return s
+}
+
+// MatchRecognize sets the row pattern matching of the events in the window.
+// The events of the stream are the matches instead of the events in the window.
+func (s *Stream) MatchRecognize(x *MatchRecognize) *Stream {
+	s.recognize = x
+	s.query.MatchRecognize = x.node()
+	s.bind(x)
+	return s
+}
+
+// Naming sets how the field names are resolved to struct fields.
+func (s *Stream) Naming(n Naming) *Stream {
+	s.naming = n
+	s.rebind()
+	return s
+}
+
+func (s *Stream) rebind() {
+	for _, sl := range s.selector {
+		s.bind(sl)
+	}
+
+	for _, a := range s.aggregator {
+		s.bind(a)
+	}
+
+	for _, w := range s.where {
+		s.bind(w)
+	}
+
+	s.bind(s.orderby)
+	if s.recognize != nil {
+		s.bind(s.recognize)
+	}
+}
+
+// bind compiles the field names used by c into index paths of the type of events in the stream,
+// and records the fields for Explain.
+// Fields of events of a Schema, or of a stream without a type, are looked up by name.
+func (s *Stream) bind(c any) {
+	b, ok := c.(binder)
+	if !ok {
+		return
+	}
+
+	fields := make([]FieldIndex, 0)
+	b.bind(func(name string) []int {
+		f := s.field(name)
+		fields = append(fields, f)
+		return f.Index
+	})
+
+	s.fields[b] = fields
+}
+
+// field returns the named field of the type of events in the stream.
+func (s *Stream) field(name string) FieldIndex {
+	f := FieldIndex{Name: name}
+	if s.from == nil {
+		return f
+	}
+
+	if _, ok := s.from.(Schema); !ok {
+		f.Index = s.naming.index(reflect.TypeOf(s.from), name)
+	}
+
+	if t, ok := s.naming.Type(s.from, name); ok && t != nil {
+		f.Type = t.String()
+	}
+
+	return f
+}
+
 func (s *Stream) Length(length int) *Stream {
 	s.window = &Length{Length: length}
+	s.query.Window = &ast.Window{Kind: lexer.LENGTH, Length: length}
 	return s
 }
 
 func (s *Stream) LengthBatch(length int) *Stream {
 	s.window = &LengthBatch{Length: length, Batch: make([]Event, 0)}
+	s.query.Window = &ast.Window{Kind: lexer.LENGTH_BATCH, Length: length}
 	return s
 }
 
 func (s *Stream) Time(expire time.Duration, unit lexer.Token) *Stream {
 	s.window = &Time{Expire: expire, Unit: unit}
+	s.query.Window = &ast.Window{Kind: lexer.TIME, Intervals: ast.Split(expire, unit)}
 	return s
 }
 
@@ -150,98 +392,286 @@ func (s *Stream) TimeBatch(expire time.Duration, unit lexer.Token) *Stream {
 		Expire: expire,
 		Unit:   unit,
 	}
+	s.query.Window = &ast.Window{Kind: lexer.TIME_BATCH, Intervals: ast.Split(expire, unit)}
 
 	return s
 }
 
 func (s *Stream) SelectAll() *Stream {
 	s.selector = append(s.selector, SelectAll{})
+	s.query.Fields = append(s.query.Fields, &ast.Star{})
 	return s
 }
 
 func (s *Stream) Select(name string) *Stream {
-	s.selector = append(s.selector, Select{Name: name})
+	sl := &Select{Name: name}
+	s.bind(sl)
+
+	s.selector = append(s.selector, sl)
+	s.query.Fields = append(s.query.Fields, ast.Field(name))
+	return s
+}
+
+// SelectExpr selects the value of the expression x such as UPPER(Message).
+func (s *Stream) SelectExpr(x Expr) *Stream {
+	sl := &SelectExpr{Expr: x}
+	s.bind(sl)
+
+	s.selector = append(s.selector, sl)
+	s.query.Fields = append(s.query.Fields, node(x))
+	return s
+}
+
+// As names the last field of the select list, e.g. SelectExpr(x).As("bucket") for x AS bucket.
+// The name is a label of the query, and the values in the result set are still in the order of the select list.
+func (s *Stream) As(name string) *Stream {
+	if len(s.query.Fields) == 0 {
+		return s
+	}
+
+	last := len(s.query.Fields) - 1
+	if a, ok := s.query.Fields[last].(*ast.Alias); ok {
+		a.Name = name
+		return s
+	}
+
+	s.query.Fields[last] = &ast.Alias{Expr: s.query.Fields[last], Name: name}
 	return s
 }
 
 func (s *Stream) Average(name string) *Stream {
-	s.aggregator = append(s.aggregator, Average{Name: name})
+	a := &Average{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.AVG, ast.Field(name))
 	return s
 }
 
 func (s *Stream) Sum(name string) *Stream {
-	s.aggregator = append(s.aggregator, Sum{Name: name})
+	a := &Sum{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.SUM, ast.Field(name))
 	return s
 }
 
 func (s *Stream) Count(name string) *Stream {
-	s.aggregator = append(s.aggregator, Count{Name: name})
+	a := &Count{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.COUNT, ast.Field(name))
 	return s
 }
 
 func (s *Stream) Max(name string) *Stream {
-	s.aggregator = append(s.aggregator, Max{Name: name})
+	a := &Max{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.MAX, ast.Field(name))
 	return s
 }
 
 func (s *Stream) Min(name string) *Stream {
-	s.aggregator = append(s.aggregator, Min{Name: name})
+	a := &Min{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.MIN, ast.Field(name))
 	return s
 }
 
 func (s *Stream) Distinct(name string) *Stream {
-	s.aggregator = append(s.aggregator, Distinct{Name: name})
+	a := &Distinct{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.DISTINCT, ast.Field(name))
+	return s
+}
+
+func (s *Stream) Variance(name string) *Stream {
+	a := &Variance{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.VARIANCE, ast.Field(name))
+	return s
+}
+
+func (s *Stream) StdDev(name string) *Stream {
+	a := &StdDev{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.STDDEV, ast.Field(name))
+	return s
+}
+
+func (s *Stream) Median(name string) *Stream {
+	a := &Median{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.MEDIAN, ast.Field(name))
+	return s
+}
+
+func (s *Stream) Percentile(name string, percent float64) *Stream {
+	a := &Percentile{Name: name, Percent: percent}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.PERCENTILE, ast.Field(name), literal(percent))
+	return s
+}
+
+func (s *Stream) ApproxPercentile(name string, percent, accuracy float64) *Stream {
+	a := &ApproxPercentile{Name: name, Percent: percent, Accuracy: accuracy}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.APPROX_PERCENTILE, ast.Field(name), literal(percent), literal(accuracy))
+	return s
+}
+
+func (s *Stream) ApproxCountDistinct(name string) *Stream {
+	a := &ApproxCountDistinct{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.APPROX_COUNT_DISTINCT, ast.Field(name))
+	return s
+}
+
+func (s *Stream) TopK(name string, k int) *Stream {
+	a := &TopK{Name: name, K: k}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.TOPK, ast.Field(name), literal(k))
+	return s
+}
+
+func (s *Stream) First(name string) *Stream {
+	a := &First{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.FIRST, ast.Field(name))
+	return s
+}
+
+func (s *Stream) Last(name string) *Stream {
+	a := &Last{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.LAST, ast.Field(name))
+	return s
+}
+
+func (s *Stream) ArrayAgg(name string) *Stream {
+	a := &ArrayAgg{Name: name}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.ARRAY_AGG, ast.Field(name))
+	return s
+}
+
+func (s *Stream) MaxBy(name, by string) *Stream {
+	a := &MaxBy{Name: name, By: by}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.MAX_BY, ast.Field(name), ast.Field(by))
+	return s
+}
+
+func (s *Stream) MinBy(name, by string) *Stream {
+	a := &MinBy{Name: name, By: by}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.call(lexer.MIN_BY, ast.Field(name), ast.Field(by))
+	return s
+}
+
+// Aggregate adds the user-defined aggregate function fn called by name over the values of x.
+// args are passed to fn for the initial state of the function.
+func (s *Stream) Aggregate(name string, fn AggregateFunc, x Expr, args ...any) *Stream {
+	a := &UserDefined{Name: name, Func: fn, Expr: x, Args: args}
+	s.bind(a)
+
+	s.aggregator = append(s.aggregator, a)
+	s.query.Fields = append(s.query.Fields, a.call())
 	return s
 }
 
 func (s *Stream) LargerThan(name string, value any) *Stream {
-	s.where = append(s.where, &LargerThan{
+	w := &LargerThan{
 		Name:  name,
 		Value: value,
-	})
+	}
+	s.bind(w)
 
+	s.where = append(s.where, w)
+	s.query.Where = ast.And(s.query.Where, &ast.BinaryExpr{Op: lexer.LARGER, X: ast.Field(name), Y: literal(value)})
 	return s
 }
 
 func (s *Stream) LessThan(name string, value any) *Stream {
-	s.where = append(s.where, &LessThan{
+	w := &LessThan{
 		Name:  name,
 		Value: value,
-	})
+	}
+	s.bind(w)
 
+	s.where = append(s.where, w)
+	s.query.Where = ast.And(s.query.Where, &ast.BinaryExpr{Op: lexer.LESS, X: ast.Field(name), Y: literal(value)})
 	return s
 }
 
 func (s *Stream) Equals(name string, value any) *Stream {
-	s.where = append(s.where, &Equal{
+	w := &Equal{
 		Name:  name,
 		Value: value,
-	})
+	}
+	s.bind(w)
 
+	s.where = append(s.where, w)
+	s.query.Where = ast.And(s.query.Where, &ast.BinaryExpr{Op: lexer.EQUALS, X: ast.Field(name), Y: literal(value)})
 	return s
 }
 
-func (s *Stream) OrderBy(name string, desc bool) *Stream {
-	if s.from == nil {
-		panic(fmt.Errorf("from is nil"))
+// Compare filters the events on the comparison of the expressions x and y with op, which is LARGER, LESS or EQUALS,
+// such as UPPER(Message) = 'GET'.
+func (s *Stream) Compare(x Expr, op lexer.Token, y Expr) *Stream {
+	w := &Compare{
+		Op: op,
+		X:  x,
+		Y:  y,
 	}
+	s.bind(w)
 
-	var index int
-	v := reflect.ValueOf(s.from)
-	t := v.Type()
-	for i := 0; i < t.NumField(); i++ {
-		if t.Field(i).Name == name {
-			index = i
-			break
-		}
-	}
+	s.where = append(s.where, w)
+	s.query.Where = ast.And(s.query.Where, node(w))
+	return s
+}
 
-	s.orderby = &OrderBy{
-		Name:  name,
-		Index: index,
-		Desc:  desc,
+func (s *Stream) OrderBy(name string, desc bool) *Stream {
+	o := &OrderBy{
+		Name: name,
+		Desc: desc,
 	}
+	s.bind(o)
 
+	s.orderby = o
+	s.query.OrderBy = &ast.OrderBy{Expr: ast.Field(name), Desc: desc}
 	return s
 }
 
@@ -250,41 +680,74 @@ func (s *Stream) Limit(limit, offset int) *Stream {
 		Limit:  limit,
 		Offset: offset,
 	}
+	s.query.Limit = &ast.Limit{Limit: limit, Offset: offset}
+
+	return s
+}
+
+// IStream sends the events inserted into the window to Deltas as New, instead of the events in the window to Output.
+func (s *Stream) IStream() *Stream {
+	s.istream = lexer.ISTREAM
+	s.query.Stream = lexer.ISTREAM
+	return s
+}
+
+// RStream sends the events expired from the window to Deltas as Old, instead of the events in the window to Output.
+func (s *Stream) RStream() *Stream {
+	s.istream = lexer.RSTREAM
+	s.query.Stream = lexer.RSTREAM
+	return s
+}
 
+// IRStream sends the events inserted into and expired from the window to Deltas as New and Old,
+// instead of the events in the window to Output.
+func (s *Stream) IRStream() *Stream {
+	s.istream = lexer.IRSTREAM
+	s.query.Stream = lexer.IRSTREAM
 	return s
 }
 
+// Rate limits the rate of the results sent to the output, such as OUTPUT LAST EVERY 10 SEC.
+func (s *Stream) Rate(r *Rate) *Stream {
+	s.rate = r
+	s.query.Output = r.node()
+	return s
+}
+
+// Query returns the syntax tree of the query the stream runs.
+func (s *Stream) Query() *ast.Query {
+	return s.query
+}
+
 func (s *Stream) String() string {
-	var buf strings.Builder
-
-	buf.WriteString("SELECT ")
-	var sel strings.Builder
-	for _, e := range s.selector {
-		sel.WriteString(e.String())
-		sel.WriteString(", ")
-	}
-	for _, e := range s.aggregator {
-		sel.WriteString(e.String())
-		sel.WriteString(", ")
-	}
-	buf.WriteString(strings.TrimRight(sel.String(), ", "))
-	buf.WriteString(" ")
-	buf.WriteString("FROM ")
-	buf.WriteString(s.where[0].String())
-	buf.WriteString(".")
-	buf.WriteString(s.window.String())
-	if len(s.where) > 1 {
-		buf.WriteString(" ")
-		buf.WriteString("WHERE ")
-	}
-	for i := 1; i < len(s.where); i++ {
-		buf.WriteString(s.where[i].String())
-	}
-	buf.WriteString(" ")
-	buf.WriteString(s.orderby.String())
-	buf.WriteString(" ")
-	buf.WriteString(s.limit.String())
-
-	rep := strings.ReplaceAll(buf.String(), "  ", " ")
-	return strings.TrimRight(rep, " ")
+	return s.query.String()
+}
+
+// call adds the function fn of args to the select list of the query.
+func (s *Stream) call(fn lexer.Token, args ...ast.Expr) {
+	s.query.Fields = append(s.query.Fields, &ast.Call{Func: fn, Args: args})
+}
+
+// literal returns v as it is written in a query.
+func literal(v any) *ast.BasicLit {
+	switch v := v.(type) {
+	case nil:
+		return &ast.BasicLit{Kind: lexer.NULL, Value: lexer.Tokens[lexer.NULL]}
+	case bool:
+		if v {
+			return &ast.BasicLit{Kind: lexer.TRUE, Value: lexer.Tokens[lexer.TRUE]}
+		}
+
+		return &ast.BasicLit{Kind: lexer.FALSE, Value: lexer.Tokens[lexer.FALSE]}
+	case time.Duration:
+		return &ast.BasicLit{Kind: lexer.DURATION, Value: v.String()}
+	case time.Time:
+		return &ast.BasicLit{Kind: lexer.TIMESTAMP, Value: v.Format(time.RFC3339Nano)}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return &ast.BasicLit{Kind: lexer.INT, Value: fmt.Sprintf("%v", v)}
+	case float32, float64:
+		return &ast.BasicLit{Kind: lexer.FLOAT, Value: fmt.Sprintf("%v", v)}
+	}
+
+	return &ast.BasicLit{Kind: lexer.STRING, Value: fmt.Sprintf("%v", v)}
 }
@@ -2,8 +2,11 @@ package stream_test
 
 import (
 	"fmt"
+	"testing"
 	"time"
 
+	"github.com/itsubaki/gostream/lexer"
+
 	"github.com/itsubaki/gostream/stream"
 )
 
@@ -26,3 +29,102 @@ func ExampleStream() {
 	// Output:
 	// SELECT * FROM LogEvent.LENGTH(10) ORDER BY Level DESC LIMIT 10 OFFSET 5
 }
+
+func ExampleStream_IRStream() {
+	type LogEvent struct {
+		Level   int
+		Message string
+	}
+
+	s := stream.New().
+		IRStream().
+		Select("Message").
+		From(LogEvent{}).
+		Length(2)
+	defer s.Close()
+
+	for i := 0; i < 4; i++ {
+		s.Listen(LogEvent{Level: i, Message: fmt.Sprintf("foo%v", i)})
+	}
+
+	fmt.Println(s)
+	for len(s.Deltas()) > 0 {
+		d := <-s.Deltas()
+
+		in, out := make([]any, 0), make([]any, 0)
+		for _, e := range d.New {
+			in = append(in, e.ResultSet...)
+		}
+
+		for _, e := range d.Old {
+			out = append(out, e.ResultSet...)
+		}
+
+		fmt.Println(in, out)
+	}
+
+	// Output:
+	// SELECT IRSTREAM Message FROM LogEvent.LENGTH(2)
+	// [foo0] []
+	// [foo1] []
+	// [foo2] [foo0]
+	// [foo3] [foo1]
+}
+
+func TestStreamDelta(t *testing.T) {
+	type LogEvent struct {
+		Level int
+	}
+
+	cases := []struct {
+		istream lexer.Token
+		want    string
+	}{
+		{lexer.ISTREAM, "[{[0 1] []} {[2 3] []}]"},
+		{lexer.RSTREAM, "[{[] [0 1]} {[] [2 3]}]"},
+		{lexer.IRSTREAM, "[{[0 1] []} {[] [0 1]} {[2 3] []} {[] [2 3]}]"},
+	}
+
+	for _, c := range cases {
+		s := stream.New().SelectAll().From(LogEvent{}).LengthBatch(2)
+		switch c.istream {
+		case lexer.ISTREAM:
+			s.IStream()
+		case lexer.RSTREAM:
+			s.RStream()
+		case lexer.IRSTREAM:
+			s.IRStream()
+		}
+
+		for i := 0; i < 5; i++ {
+			s.Listen(LogEvent{Level: i})
+		}
+
+		type levels struct{ New, Old []int }
+		got := make([]levels, 0)
+		for len(s.Deltas()) > 0 {
+			d := <-s.Deltas()
+
+			var l levels
+			for _, e := range d.New {
+				l.New = append(l.New, e.Underlying.(LogEvent).Level)
+			}
+
+			for _, e := range d.Old {
+				l.Old = append(l.Old, e.Underlying.(LogEvent).Level)
+			}
+
+			got = append(got, l)
+		}
+
+		if fmt.Sprint(got) != c.want {
+			t.Errorf("%v: got=%v, want=%v", s, got, c.want)
+		}
+
+		if len(s.Output()) > 0 {
+			t.Errorf("%v: output=%v", s, len(s.Output()))
+		}
+
+		s.Close()
+	}
+}
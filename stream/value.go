@@ -0,0 +1,176 @@
+package stream
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// normalize returns the underlying value of v, so that values of different Go types can be compared.
+// Pointers are dereferenced (nil becomes nil), and every signed integer, unsigned integer, float,
+// bool and string, including named types such as `type Level int`, is converted to
+// int64, uint64, float64, bool and string respectively. time.Time is returned as is.
+func normalize(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Invalid:
+		return nil
+	}
+
+	return rv.Interface()
+}
+
+// unhashable is the key of a value whose type cannot be a map key, such as a slice, by its Go syntax.
+type unhashable string
+
+// key returns v normalized as a map key, so that numbers of the same value have the same key,
+// e.g. 1, int64(1) and 1.0, and so do the same instants of time.Time in any location.
+// A value that cannot be a map key, such as a slice, is keyed by its Go syntax.
+func key(v any) any {
+	switch v := normalize(v).(type) {
+	case nil:
+		return nil
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+
+		return v
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v)
+		}
+
+		return v
+	case time.Time:
+		return v.UTC().Round(0)
+	default:
+		if !reflect.ValueOf(v).Comparable() {
+			return unhashable(fmt.Sprintf("%T %#v", v, v))
+		}
+
+		return v
+	}
+}
+
+// float returns v as float64 if it is a number.
+func float(v any) (float64, bool) {
+	switch v := normalize(v).(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+// compare returns -1, 0 or +1 depending on whether a is less than, equal to or greater than b.
+// Numbers of any type, including time.Duration, are compared by value,
+// and strings, bools (false < true) and time.Time are compared with values of the same kind.
+// Otherwise, e.g. for a nil pointer, it returns false.
+func compare(a, b any) (int, bool) {
+	switch a := normalize(a).(type) {
+	case int64:
+		switch b := normalize(b).(type) {
+		case int64:
+			return cmp.Compare(a, b), true
+		case uint64:
+			if a < 0 {
+				return -1, true
+			}
+
+			return cmp.Compare(uint64(a), b), true
+		case float64:
+			return cmp.Compare(float64(a), b), true
+		}
+	case uint64:
+		switch b := normalize(b).(type) {
+		case int64:
+			if b < 0 {
+				return 1, true
+			}
+
+			return cmp.Compare(a, uint64(b)), true
+		case uint64:
+			return cmp.Compare(a, b), true
+		case float64:
+			return cmp.Compare(float64(a), b), true
+		}
+	case float64:
+		if b, ok := float(b); ok {
+			return cmp.Compare(a, b), true
+		}
+	case string:
+		if b, ok := normalize(b).(string); ok {
+			return cmp.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := normalize(b).(bool); ok {
+			return cmp.Compare(btoi(a), btoi(b)), true
+		}
+	case time.Time:
+		if b, ok := normalize(b).(time.Time); ok {
+			return a.Compare(b), true
+		}
+	}
+
+	return 0, false
+}
+
+// equal reports whether a and b have the same value.
+func equal(a, b any) bool {
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+
+	return normalize(a) == nil && normalize(b) == nil
+}
+
+// lesseq reports whether a is less than or equal to b.
+func lesseq(a, b any) bool {
+	c, ok := compare(a, b)
+	return ok && c <= 0
+}
+
+// greatereq reports whether a is greater than or equal to b.
+func greatereq(a, b any) bool {
+	c, ok := compare(a, b)
+	return ok && c >= 0
+}
+
+// ordered reports whether v has an ordered type.
+func ordered(v any) bool {
+	_, ok := compare(v, v)
+	return ok
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -12,6 +12,15 @@ var (
 	_ Where = (*Equal)(nil)
 	_ Where = (*NotEqual)(nil)
 	_ Where = (*And)(nil)
+	_ Where = (*Compare)(nil)
+)
+
+var (
+	_ binder = (*LargerThan)(nil)
+	_ binder = (*LessThan)(nil)
+	_ binder = (*Equal)(nil)
+	_ binder = (*NotEqual)(nil)
+	_ binder = (*And)(nil)
 )
 
 type Where interface {
@@ -24,35 +33,43 @@ type From struct {
 }
 
 func (w From) Apply(input any) bool {
+	if s, ok := w.Type.(Schema); ok {
+		return s.Match(input)
+	}
+
 	return reflect.TypeOf(input) == reflect.TypeOf(w.Type)
 }
 
 func (w From) String() string {
+	if s, ok := w.Type.(Schema); ok {
+		return s.Name
+	}
+
+	if w.Type == nil {
+		return ""
+	}
+
 	return reflect.TypeOf(w.Type).Name()
 }
 
 type LargerThan struct {
 	Name  string
+	Index []int
 	Value any
 }
 
 func (w LargerThan) Apply(input any) bool {
-	v := reflect.ValueOf(input).FieldByName(w.Name).Interface()
-
-	switch val := w.Value.(type) {
-	case int:
-		return v.(int) > val
-	case int32:
-		return v.(int32) > val
-	case int64:
-		return v.(int64) > val
-	case float32:
-		return v.(float32) > val
-	case float64:
-		return v.(float64) > val
+	v, ok := field(input, w.Name, w.Index)
+	if !ok {
+		return false
 	}
 
-	return true
+	c, ok := compare(v, w.Value)
+	return ok && c > 0
+}
+
+func (w *LargerThan) bind(r resolver) {
+	w.Index = r(w.Name)
 }
 
 func (w LargerThan) String() string {
@@ -61,26 +78,22 @@ func (w LargerThan) String() string {
 
 type LessThan struct {
 	Name  string
+	Index []int
 	Value any
 }
 
 func (w LessThan) Apply(input any) bool {
-	v := reflect.ValueOf(input).FieldByName(w.Name).Interface()
-
-	switch val := w.Value.(type) {
-	case int:
-		return v.(int) < val
-	case int32:
-		return v.(int32) < val
-	case int64:
-		return v.(int64) < val
-	case float32:
-		return v.(float32) < val
-	case float64:
-		return v.(float64) < val
+	v, ok := field(input, w.Name, w.Index)
+	if !ok {
+		return false
 	}
 
-	return true
+	c, ok := compare(v, w.Value)
+	return ok && c < 0
+}
+
+func (w *LessThan) bind(r resolver) {
+	w.Index = r(w.Name)
 }
 
 func (w LessThan) String() string {
@@ -89,28 +102,21 @@ func (w LessThan) String() string {
 
 type Equal struct {
 	Name  string
+	Index []int
 	Value any
 }
 
 func (w Equal) Apply(input any) bool {
-	v := reflect.ValueOf(input).FieldByName(w.Name).Interface()
-
-	switch val := w.Value.(type) {
-	case int:
-		return v.(int) == val
-	case int32:
-		return v.(int32) == val
-	case int64:
-		return v.(int64) == val
-	case float32:
-		return v.(float32) == val
-	case float64:
-		return v.(float64) == val
-	case string:
-		return v.(string) == val
+	v, ok := field(input, w.Name, w.Index)
+	if !ok {
+		return false
 	}
 
-	return true
+	return equal(v, w.Value)
+}
+
+func (w *Equal) bind(r resolver) {
+	w.Index = r(w.Name)
 }
 
 func (w Equal) String() string {
@@ -119,28 +125,21 @@ func (w Equal) String() string {
 
 type NotEqual struct {
 	Name  string
+	Index []int
 	Value any
 }
 
 func (w NotEqual) Apply(input any) bool {
-	v := reflect.ValueOf(input).FieldByName(w.Name).Interface()
-
-	switch val := w.Value.(type) {
-	case int:
-		return v.(int) != val
-	case int32:
-		return v.(int32) != val
-	case int64:
-		return v.(int64) != val
-	case float32:
-		return v.(float32) != val
-	case float64:
-		return v.(float64) != val
-	case string:
-		return v.(string) != val
+	v, ok := field(input, w.Name, w.Index)
+	if !ok {
+		return false
 	}
 
-	return true
+	return !equal(v, w.Value)
+}
+
+func (w *NotEqual) bind(r resolver) {
+	w.Index = r(w.Name)
 }
 
 func (w NotEqual) String() string {
@@ -156,6 +155,16 @@ func (w And) Apply(input any) bool {
 	return w.Lhs.Apply(input) && w.Rhs.Apply(input)
 }
 
+func (w *And) bind(r resolver) {
+	if b, ok := w.Lhs.(binder); ok {
+		b.bind(r)
+	}
+
+	if b, ok := w.Rhs.(binder); ok {
+		b.bind(r)
+	}
+}
+
 func (w And) String() string {
 	return fmt.Sprintf("%v AND %v", w.Lhs, w.Rhs)
 }
@@ -65,3 +65,78 @@ func TestWhere(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkWhere(b *testing.B) {
+	type LogEvent struct {
+		Time    time.Time
+		Level   int
+		Message string
+	}
+
+	cases := []struct {
+		name string
+		in   stream.Where
+	}{
+		{"FieldByName", stream.LargerThan{Name: "Level", Value: 2}},
+		{"FieldByIndex", stream.LargerThan{Name: "Level", Index: []int{1}, Value: 2}},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.in.Apply(LogEvent{Level: i})
+			}
+		})
+	}
+}
+
+func TestWhereCoercion(t *testing.T) {
+	type Level int
+	type LogEvent struct {
+		Level   Level
+		Code    uint8
+		Latency int64
+		Score   *float32
+		Ok      bool
+		Message *string
+	}
+
+	score, msg := float32(0.5), "foo"
+	in := LogEvent{
+		Level:   3,
+		Code:    200,
+		Latency: 1500,
+		Score:   &score,
+		Ok:      true,
+		Message: &msg,
+	}
+
+	cases := []struct {
+		w    stream.Where
+		want bool
+	}{
+		{stream.LargerThan{Name: "Level", Value: 2}, true},
+		{stream.Equal{Name: "Level", Value: 3.0}, true},
+		{stream.LessThan{Name: "Code", Value: 300}, true},
+		{stream.LargerThan{Name: "Code", Value: -1}, true},
+		{stream.LargerThan{Name: "Latency", Value: 3}, true},
+		{stream.LargerThan{Name: "Latency", Value: 1500.5}, false},
+		{stream.LessThan{Name: "Score", Value: 1}, true},
+		{stream.Equal{Name: "Ok", Value: true}, true},
+		{stream.NotEqual{Name: "Ok", Value: false}, true},
+		{stream.Equal{Name: "Message", Value: "foo"}, true},
+		{stream.LargerThan{Name: "Message", Value: 3}, false},
+	}
+
+	for _, c := range cases {
+		got := c.w.Apply(in)
+		if got != c.want {
+			t.Errorf("%v: want=%v, got=%v", c.w, c.want, got)
+		}
+	}
+
+	nilptr := LogEvent{}
+	if (stream.LessThan{Name: "Score", Value: 1}).Apply(nilptr) {
+		t.Errorf("nil pointer must not match")
+	}
+}
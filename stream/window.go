@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/itsubaki/gostream/ast"
 	"github.com/itsubaki/gostream/lexer"
 )
 
@@ -56,6 +57,9 @@ func (w *LengthBatch) String() string {
 	return fmt.Sprintf("LENGTH_BATCH(%v)", w.Length)
 }
 
+// Time keeps the events that arrived within Expire.
+// Unit is the unit of time Expire is written in, e.g. MIN for TIME(1.5 MIN).
+// If it is not a unit of time, e.g. the zero value, Expire is written in the largest units, e.g. TIME(1 MIN 30 SEC).
 type Time struct {
 	Expire time.Duration
 	Unit   lexer.Token
@@ -73,17 +77,11 @@ func (w *Time) Apply(e []Event) []Event {
 }
 
 func (w *Time) String() string {
-	v := w.Expire.Seconds()
-	if w.Unit == lexer.MIN {
-		v = w.Expire.Minutes()
-	}
-	if w.Unit == lexer.HOUR {
-		v = w.Expire.Hours()
-	}
-
-	return fmt.Sprintf("TIME(%v %v)", v, lexer.Tokens[w.Unit])
+	return fmt.Sprintf("TIME(%v)", ast.Split(w.Expire, w.Unit))
 }
 
+// TimeBatch keeps the events that arrived in the current batch of length Expire.
+// Unit is the unit of time Expire is written in as in Time.
 type TimeBatch struct {
 	Start  time.Time
 	End    time.Time
@@ -112,13 +110,31 @@ func (w *TimeBatch) Apply(e []Event) []Event {
 }
 
 func (w *TimeBatch) String() string {
-	v := w.Expire.Seconds()
-	if w.Unit == lexer.MIN {
-		v = w.Expire.Minutes()
+	return fmt.Sprintf("TIME_BATCH(%v)", ast.Split(w.Expire, w.Unit))
+}
+
+// delta returns the events inserted into and expired from a window,
+// given its contents before and after Apply. Every window keeps events
+// in arrival order, so expired events are a prefix of prev and inserted
+// events are a suffix of next.
+func delta(prev, next []Event) (in, out []Event) {
+	if len(next) == 0 {
+		return nil, prev
 	}
-	if w.Unit == lexer.HOUR {
-		v = w.Expire.Hours()
+
+	if len(prev) == 0 {
+		return next, nil
+	}
+
+	var i int
+	for i < len(prev) && prev[i].seq < next[0].seq {
+		i++
+	}
+
+	j := len(next)
+	for j > 0 && next[j-1].seq > prev[len(prev)-1].seq {
+		j--
 	}
 
-	return fmt.Sprintf("TIME_BATCH(%v %v)", v, lexer.Tokens[w.Unit])
+	return next[j:], prev[:i]
 }
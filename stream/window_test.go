@@ -17,6 +17,13 @@ func TestWindowString(t *testing.T) {
 		{&stream.LengthBatch{Length: 10}, "LENGTH_BATCH(10)"},
 		{&stream.Time{Expire: 10 * time.Minute, Unit: lexer.MIN}, "TIME(10 MIN)"},
 		{&stream.TimeBatch{Expire: 10 * time.Minute, Unit: lexer.MIN}, "TIME_BATCH(10 MIN)"},
+		{&stream.Time{Expire: 500 * time.Millisecond, Unit: lexer.SEC}, "TIME(0.5 SEC)"},
+		{&stream.Time{Expire: 250 * time.Millisecond, Unit: lexer.MSEC}, "TIME(250 MSEC)"},
+		{&stream.Time{Expire: 36 * time.Hour, Unit: lexer.DAY}, "TIME(1.5 DAY)"},
+		{&stream.Time{Expire: 90 * time.Second}, "TIME(1 MIN 30 SEC)"},
+		{&stream.Time{Expire: 8*24*time.Hour + 1500*time.Microsecond}, "TIME(1 WEEK 1 DAY 1.5 MSEC)"},
+		{&stream.TimeBatch{Expire: 14 * 24 * time.Hour, Unit: lexer.WEEK}, "TIME_BATCH(2 WEEK)"},
+		{&stream.TimeBatch{Expire: 61 * time.Minute}, "TIME_BATCH(1 HOUR 1 MIN)"},
 	}
 
 	for _, c := range cases {